@@ -0,0 +1,376 @@
+package autorest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyStore authenticates API keys kept in a database table. Only a SHA-256
+// hash of each key is stored. Each key has a principal, roles and grants, and
+// may only be used for the tables and actions it was granted. Lookups are
+// cached for CacheTTL, so a key that expires may keep working for up to
+// CacheTTL. Revoking or rotating a key through the admin endpoints takes
+// effect immediately. Unknown, expired and revoked keys are remembered for
+// FailureCacheTTL, so repeating a bad key doesn't cost a query each time.
+type APIKeyStore struct {
+	Header          string
+	QueryParameter  string
+	CacheTTL        time.Duration
+	FailureCacheTTL time.Duration
+	handler         *Handler
+	table           string
+	mutex           sync.Mutex
+	cache           map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	id        int64
+	principal *Principal
+	expires   time.Time
+}
+
+type apiKeyRequest struct {
+	Name      string              `json:"name"`
+	Principal string              `json:"principal"`
+	Roles     []string            `json:"roles"`
+	Grants    map[string][]string `json:"grants"`
+	ExpiresAt *string             `json:"expires_at"`
+}
+
+const MAX_CACHED_API_KEYS = 10000
+
+var apiKeyColumns = []string{"id", "name", "secret_hash", "principal", "roles", "grants", "expires_at", "revoked_at", "created_at"}
+
+// EnableAPIKeyStore authenticates callers with the keys in the given table
+// and serves endpoints to manage them under path, which only callers with
+// adminRole may use.
+func (s *Server) EnableAPIKeyStore(tableName, path, adminRole string) *APIKeyStore {
+	table := s.handler.mustGetTable(tableName)
+	for _, column := range apiKeyColumns {
+		if !table.HasColumn(column) {
+			panic("API key table " + tableName + " has no column " + column)
+		}
+	}
+	s.handler.systemTables[tableName] = true
+	store := &APIKeyStore{
+		Header:          "X-API-Key",
+		QueryParameter:  "api_key",
+		CacheTTL:        time.Minute,
+		FailureCacheTTL: 10 * time.Second,
+		handler:         s.handler,
+		table:           tableName,
+		cache:           make(map[string]cachedAPIKey),
+	}
+	s.AddAuthenticator(store)
+	path = strings.TrimSuffix(path, "/")
+	admin := &apiKeyAdmin{server: s, store: store, path: path, role: adminRole}
+	s.mux.Handle(path, admin)
+	s.mux.Handle(path+"/", admin)
+	return store
+}
+
+func (store *APIKeyStore) Authenticate(r *http.Request) (*Principal, error) {
+	key := extractAPIKey(r, store.Header, store.QueryParameter)
+	if key == "" {
+		return nil, nil
+	}
+	hash := hashAPIKey(key)
+	store.mutex.Lock()
+	cached, ok := store.cache[hash]
+	store.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.principal == nil {
+			return nil, ErrInvalidCredentials
+		}
+		return cached.principal, nil
+	}
+	id, principal, err := store.lookup(r.Context(), hash)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		store.remember(hash, cachedAPIKey{expires: time.Now().Add(store.FailureCacheTTL)})
+		return nil, ErrInvalidCredentials
+	}
+	store.remember(hash, cachedAPIKey{id: id, principal: principal, expires: time.Now().Add(store.CacheTTL)})
+	return principal, nil
+}
+
+// remember caches a lookup, first dropping expired entries once the cache is
+// full so that a stream of made-up keys can't grow it without bound.
+func (store *APIKeyStore) remember(hash string, entry cachedAPIKey) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(store.cache) >= MAX_CACHED_API_KEYS {
+		now := time.Now()
+		for cachedHash, cached := range store.cache {
+			if !now.Before(cached.expires) {
+				delete(store.cache, cachedHash)
+			}
+		}
+		if len(store.cache) >= MAX_CACHED_API_KEYS {
+			return
+		}
+	}
+	store.cache[hash] = entry
+}
+
+func (store *APIKeyStore) Challenge() string {
+	return "ApiKey realm=\"autorest\""
+}
+
+func (store *APIKeyStore) lookup(ctx context.Context, hash string) (int64, *Principal, error) {
+	h := store.handler
+	rows, err := h.query(ctx, h.db, h.queryBuilder.BuildAPIKeyLookupQuery(store.table), []interface{}{hash})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rows) == 0 {
+		return 0, nil, nil
+	}
+	row := rows[0]
+	principal := &Principal{Id: stringValue(row["principal"])}
+	if roles := stringValue(row["roles"]); roles != "" {
+		if err = json.Unmarshal([]byte(roles), &principal.Roles); err != nil {
+			return 0, nil, err
+		}
+	}
+	grantedActions := make(map[string][]string)
+	if err = json.Unmarshal([]byte(stringValue(row["grants"])), &grantedActions); err != nil {
+		return 0, nil, err
+	}
+	principal.grants = make(grants)
+	for table, actionList := range grantedActions {
+		principal.grants.add(table, actionsFromNames(actionList))
+	}
+	id, _ := strconv.ParseInt(stringValue(row["id"]), 10, 64)
+	return id, principal, nil
+}
+
+func (store *APIKeyStore) forget(id int64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for hash, cached := range store.cache {
+		if cached.id == id {
+			delete(store.cache, hash)
+		}
+	}
+}
+
+func requireRowsAffected(result sql.Result) error {
+	if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected == 0 {
+		return ApiError{NOT_FOUND}
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func actionsFromNames(names []string) []int {
+	actions := make([]int, 0, len(names))
+	for _, name := range names {
+		for action, actionName := range actionNames {
+			if strings.EqualFold(name, actionName) {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions
+}
+
+func stringValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return strconv.FormatInt(toInt64(value), 10)
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+type apiKeyAdmin struct {
+	server *Server
+	store  *APIKeyStore
+	path   string
+	role   string
+}
+
+// ServeHTTP handles
+//
+//	GET    path               list keys
+//	POST   path               create a key
+//	DELETE path/:id           revoke a key
+//	POST   path/:id/_rotate   replace the secret of a key
+func (a *apiKeyAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	principal, err := a.server.authenticate(w, r)
+	if err != nil {
+		a.server.respondWithError(err, w)
+		return
+	}
+	if principal == nil {
+		a.server.respondWithError(ApiError{UNAUTHORIZED}, w)
+		return
+	}
+	if !principal.HasRole(a.role) {
+		a.server.respondWithError(ApiError{FORBIDDEN}, w)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, a.path), "/"), "/")
+	var id int64
+	if parts[0] != "" {
+		if id, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			a.server.respondWithError(ApiError{BAD_REQUEST}, w)
+			return
+		}
+	}
+	switch {
+	case r.Method == "GET" && id == 0:
+		a.list(w, r)
+	case r.Method == "POST" && id == 0:
+		a.create(w, r)
+	case r.Method == "DELETE" && id != 0 && len(parts) == 1:
+		a.revoke(w, r, id)
+	case r.Method == "POST" && id != 0 && len(parts) == 2 && parts[1] == "_rotate":
+		a.rotate(w, r, id)
+	default:
+		a.server.respondWithError(ApiError{NOT_FOUND}, w)
+	}
+}
+
+func (a *apiKeyAdmin) list(w http.ResponseWriter, r *http.Request) {
+	h := a.store.handler
+	rows, err := h.query(r.Context(), h.db, h.queryBuilder.BuildAPIKeyListQuery(a.store.table), nil)
+	if err != nil {
+		a.server.respondWithError(err, w)
+		return
+	}
+	for _, row := range rows {
+		for _, key := range []string{"roles", "grants"} {
+			if data, ok := row[key].(string); ok {
+				row[key] = json.RawMessage(data)
+			}
+		}
+	}
+	a.server.respond(OK, rows, w)
+}
+
+func (a *apiKeyAdmin) create(w http.ResponseWriter, r *http.Request) {
+	var keyRequest apiKeyRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&keyRequest); err != nil || keyRequest.Principal == "" || keyRequest.Grants == nil {
+		a.server.respondWithError(ApiError{BAD_REQUEST}, w)
+		return
+	}
+	key, err := generateAPIKey()
+	if err != nil {
+		a.server.logger.Error(err.Error())
+		a.server.respondWithError(ApiError{INTERNAL_SERVER_ERROR}, w)
+		return
+	}
+	if keyRequest.Roles == nil {
+		keyRequest.Roles = make([]string, 0)
+	}
+	roles, _ := json.Marshal(keyRequest.Roles)
+	grantData, _ := json.Marshal(keyRequest.Grants)
+	h := a.store.handler
+	values := []interface{}{keyRequest.Name, hashAPIKey(key), keyRequest.Principal, string(roles), string(grantData), keyRequest.ExpiresAt}
+	result, err := h.exec(r.Context(), h.db, h.queryBuilder.BuildAPIKeyInsertQuery(a.store.table), values)
+	if err != nil {
+		a.server.respondWithError(err, w)
+		return
+	}
+	id, _ := result.LastInsertId()
+	w.Header().Set("Location", a.path+"/"+strconv.FormatInt(id, 10))
+	a.server.respond(CREATED, map[string]interface{}{
+		"id":         id,
+		"name":       keyRequest.Name,
+		"principal":  keyRequest.Principal,
+		"roles":      keyRequest.Roles,
+		"grants":     keyRequest.Grants,
+		"expires_at": keyRequest.ExpiresAt,
+		"key":        key,
+	}, w)
+}
+
+func (a *apiKeyAdmin) revoke(w http.ResponseWriter, r *http.Request, id int64) {
+	h := a.store.handler
+	result, err := h.exec(r.Context(), h.db, h.queryBuilder.BuildAPIKeyRevokeQuery(a.store.table), []interface{}{id})
+	if err == nil {
+		err = requireRowsAffected(result)
+	}
+	if err != nil {
+		a.server.respondWithError(err, w)
+		return
+	}
+	a.store.forget(id)
+	w.WriteHeader(NO_CONTENT)
+}
+
+func (a *apiKeyAdmin) rotate(w http.ResponseWriter, r *http.Request, id int64) {
+	key, err := generateAPIKey()
+	if err != nil {
+		a.server.logger.Error(err.Error())
+		a.server.respondWithError(ApiError{INTERNAL_SERVER_ERROR}, w)
+		return
+	}
+	h := a.store.handler
+	result, err := h.exec(r.Context(), h.db, h.queryBuilder.BuildAPIKeyRotateQuery(a.store.table), []interface{}{hashAPIKey(key), id})
+	if err == nil {
+		err = requireRowsAffected(result)
+	}
+	if err != nil {
+		a.server.respondWithError(err, w)
+		return
+	}
+	a.store.forget(id)
+	a.server.respond(OK, map[string]interface{}{"id": id, "key": key}, w)
+}
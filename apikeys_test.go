@@ -0,0 +1,130 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getAPIKeyStoreForTesting(t *testing.T) (*APIKeyStore, sqlmock.Sqlmock) {
+	handler, mock := getHandlerForTesting(t)
+	store := &APIKeyStore{
+		Header:          "X-API-Key",
+		CacheTTL:        time.Minute,
+		FailureCacheTTL: time.Minute,
+		handler:         handler,
+		table:           "api_keys",
+		cache:           make(map[string]cachedAPIKey),
+	}
+	return store, mock
+}
+
+func TestAPIKeyStoreAuthenticatesAndCaches(t *testing.T) {
+	store, mock := getAPIKeyStoreForTesting(t)
+	mock.ExpectPrepare("SELECT id, principal, roles, grants FROM api_keys WHERE secret_hash=\\?").
+		ExpectQuery().
+		WithArgs(hashAPIKey("secret")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "principal", "roles", "grants"}).
+			AddRow(3, []byte("reporting"), []byte("[\"reader\"]"), []byte("{\"products\":[\"GET\",\"GET_ALL\"]}")))
+	r := httptest.NewRequest("GET", "/rest/products", nil)
+	r.Header.Set("X-API-Key", "secret")
+	for i := 0; i < 2; i++ {
+		principal, err := store.Authenticate(r)
+		if err != nil || principal.Id != "reporting" || !principal.HasRole("reader") {
+			t.Fatalf("Expected the reporting principal but got %v, %v", principal, err)
+		}
+		if !principal.grants.allows("products", GET_ALL) || principal.grants.allows("products", DELETE) {
+			t.Errorf("Expected the key to be granted only GET and GET_ALL on products")
+		}
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(store.handler)
+}
+
+func TestAPIKeyStoreRejectsUnknownKeys(t *testing.T) {
+	store, mock := getAPIKeyStoreForTesting(t)
+	mock.ExpectPrepare("SELECT id, principal, roles, grants FROM api_keys WHERE secret_hash=\\?").
+		ExpectQuery().
+		WithArgs(hashAPIKey("unknown")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "principal", "roles", "grants"}))
+	r := httptest.NewRequest("GET", "/rest/products?api_key=unknown", nil)
+	store.QueryParameter = "api_key"
+	for i := 0; i < 2; i++ {
+		if _, err := store.Authenticate(r); err != ErrInvalidCredentials {
+			t.Errorf("Expected invalid credentials but got %v", err)
+		}
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(store.handler)
+}
+
+func TestGrantsLimitAPIKeys(t *testing.T) {
+	handler, _ := getHandlerForTesting(t)
+	principal := &Principal{Id: "reporting", grants: grants{"products": {GET_ALL: true}}}
+	checkAuthorization(t, handler, Request{Table: "products", Action: GET_ALL, Principal: principal}, OK)
+	checkAuthorization(t, handler, Request{Table: "users", Action: GET_ALL, Principal: principal}, FORBIDDEN)
+	cleanUp(handler)
+}
+
+func TestGrantsDoNotWidenPolicies(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.Allow("products", GET_ALL)
+	principal := &Principal{Id: "cleanup", grants: grants{"products": {GET_ALL: true, DELETE: true}}}
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: GET_ALL, Principal: principal}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: DELETE, Principal: principal}, FORBIDDEN)
+	cleanUp(server.handler)
+}
+
+func TestAPIKeyAdminRequiresAdminRole(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	keys := NewAPIKeyAuthenticator("X-Admin-Key", "")
+	keys.AddKey("not-admin", &Principal{Id: "alice"})
+	server.AddAuthenticator(keys)
+	store, _ := getAPIKeyStoreForTesting(t)
+	admin := &apiKeyAdmin{server: server, store: store, path: "/admin/keys", role: "admin"}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin/keys", strings.NewReader("{}"))
+	r.Header.Set("X-Admin-Key", "not-admin")
+	admin.ServeHTTP(w, r)
+	if w.Code != FORBIDDEN {
+		t.Errorf("Expected status code %d but got %d", FORBIDDEN, w.Code)
+	}
+	cleanUp(server.handler)
+	cleanUp(store.handler)
+}
+
+func TestAPIKeyAdminCreatesKeys(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	keys := NewAPIKeyAuthenticator("X-Admin-Key", "")
+	keys.AddKey("admin", &Principal{Id: "root", Roles: []string{"admin"}})
+	server.AddAuthenticator(keys)
+	store, mock := getAPIKeyStoreForTesting(t)
+	mock.ExpectPrepare("INSERT INTO api_keys \\(name,secret_hash,principal,roles,grants,expires_at,created_at\\)").
+		ExpectExec().
+		WithArgs("reports", sqlmock.AnyArg(), "reporting", "[]", "{\"products\":[\"GET_ALL\"]}", nil).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	admin := &apiKeyAdmin{server: server, store: store, path: "/admin/keys", role: "admin"}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin/keys", strings.NewReader("{\"name\":\"reports\",\"principal\":\"reporting\",\"grants\":{\"products\":[\"GET_ALL\"]}}"))
+	r.Header.Set("X-Admin-Key", "admin")
+	admin.ServeHTTP(w, r)
+	if w.Code != CREATED {
+		t.Errorf("Expected status code %d but got %d", CREATED, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "\"key\":") {
+		t.Errorf("Expected the new key in the response but got %s", w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+	cleanUp(store.handler)
+}
+
+func TestStringValueOfIntegers(t *testing.T) {
+	for _, value := range []interface{}{7, int8(7), int16(7), int32(7), int64(7), uint(7), uint8(7), uint16(7), uint32(7), uint64(7)} {
+		if s := stringValue(value); s != "7" {
+			t.Errorf("Expected %T 7 to be formatted as 7 but got %s", value, s)
+		}
+	}
+}
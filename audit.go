@@ -0,0 +1,122 @@
+package autorest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// performAuditedWrite records a write in the audit table, with snapshots of
+// the row before and after it. Snapshots hold every column, whatever the
+// writer's column access; History restricts them to the reader's.
+func (h *Handler) performAuditedWrite(tx Executor, r Request) (interface{}, error) {
+	snapshot := r
+	snapshot.IncludeDeleted = true
+	snapshot.unrestricted = true
+	var before interface{}
+	if r.Action != POST {
+		if current, err := h.Get(tx, snapshot); err == nil {
+			before = current
+		}
+	}
+	result, err := h.performWrite(tx, r)
+	if err != nil {
+		return nil, err
+	}
+	recordId := interface{}(r.Id)
+	if r.Action == POST {
+		if item, ok := result.(map[string]interface{}); ok {
+			recordId = item[h.GetTable(r.Table).PKColumn]
+		}
+	}
+	after := result
+	if _, isRow := result.(map[string]interface{}); isRow && h.GetTable(r.Table).hasRestrictedColumnsFor(r.Principal) {
+		if isIntegerValue(recordId) {
+			snapshot.Id = toInt64(recordId)
+		}
+		if current, err := h.Get(tx, snapshot); err == nil {
+			after = current
+		}
+	}
+	if err = h.recordChange(tx, r, recordId, before, after); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (h *Handler) recordChange(tx Executor, r Request, recordId, before, after interface{}) error {
+	beforeData, err := marshalSnapshot(before)
+	if err != nil {
+		h.logger.Error(err.Error())
+		return ApiError{INTERNAL_SERVER_ERROR}
+	}
+	afterData, err := marshalSnapshot(after)
+	if err != nil {
+		h.logger.Error(err.Error())
+		return ApiError{INTERNAL_SERVER_ERROR}
+	}
+	values := []interface{}{r.Table, recordId, r.actionName(), principalId(r.Principal), beforeData, afterData}
+	_, err = h.exec(r.context(), tx, h.queryBuilder.BuildAuditInsertQuery(h.auditTable), values)
+	return err
+}
+
+func marshalSnapshot(snapshot interface{}) (interface{}, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (h *Handler) History(db Executor, r Request) (interface{}, error) {
+	if h.auditTable == "" {
+		return nil, ApiError{NOT_FOUND}
+	}
+	if len(r.scope) > 0 {
+		visible := r
+		visible.IncludeDeleted = true
+		if _, err := h.Get(db, visible); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := h.query(r.context(), db, h.queryBuilder.BuildHistoryQuery(h.auditTable), []interface{}{r.Table, r.Id})
+	if err != nil {
+		return nil, err
+	}
+	table := h.GetTable(r.Table)
+	for _, entry := range entries {
+		for _, key := range []string{"before_data", "after_data"} {
+			if data, ok := entry[key].(string); ok {
+				if entry[key], err = h.restrictSnapshot(r, table, data); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// restrictSnapshot applies the column access of the caller reading the
+// history to a snapshot, which holds every column.
+func (h *Handler) restrictSnapshot(r Request, table *Table, data string) (json.RawMessage, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil || row == nil {
+		return json.RawMessage(data), nil
+	}
+	for _, column := range table.Columns {
+		if column.isHiddenFor(r.Principal) {
+			delete(row, column.Name)
+		}
+	}
+	h.maskColumns(r, table, []map[string]interface{}{row})
+	restricted, err := json.Marshal(row)
+	if err != nil {
+		h.logger.Error(err.Error())
+		return nil, ApiError{INTERNAL_SERVER_ERROR}
+	}
+	return json.RawMessage(restricted), nil
+}
@@ -0,0 +1,167 @@
+package autorest
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"sync"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator identifies the caller of a request. Authenticate returns a nil
+// Principal and a nil error when the request carries no credentials that the
+// authenticator understands, so that the next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	Challenge() string
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromRequest returns the authenticated caller of a request, or nil
+// for anonymous callers.
+func PrincipalFromRequest(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+func withPrincipal(r *http.Request, principal *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal))
+}
+
+func (s *Server) AddAuthenticator(authenticator Authenticator) {
+	s.authenticators = append(s.authenticators, authenticator)
+	s.handler.requireAuthentication = true
+}
+
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*Principal, error) {
+	if err := s.rateLimiter.allowAuthentication(r, w); err != nil {
+		return nil, err
+	}
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			s.logger.Info("Authentication failed: " + err.Error())
+			s.rateLimiter.chargeFailedAuthentication(r)
+			return nil, ApiError{UNAUTHORIZED}
+		}
+		if principal != nil {
+			return principal, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) setChallenges(w http.ResponseWriter) {
+	for _, authenticator := range s.authenticators {
+		w.Header().Add("WWW-Authenticate", authenticator.Challenge())
+	}
+}
+
+type APIKeyAuthenticator struct {
+	Header         string
+	QueryParameter string
+	keys           map[[sha256.Size]byte]*Principal
+}
+
+// NewAPIKeyAuthenticator accepts keys sent in the given header or query
+// parameter. Either may be left empty to disable it.
+func NewAPIKeyAuthenticator(header, queryParameter string) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		Header:         header,
+		QueryParameter: queryParameter,
+		keys:           make(map[[sha256.Size]byte]*Principal),
+	}
+}
+
+func (a *APIKeyAuthenticator) AddKey(key string, principal *Principal) {
+	a.keys[sha256.Sum256([]byte(key))] = principal
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := extractAPIKey(r, a.Header, a.QueryParameter)
+	if key == "" {
+		return nil, nil
+	}
+	principal, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+func (a *APIKeyAuthenticator) Challenge() string {
+	return "ApiKey realm=\"autorest\""
+}
+
+func extractAPIKey(r *http.Request, header, queryParameter string) string {
+	if header != "" {
+		if key := r.Header.Get(header); key != "" {
+			return key
+		}
+	}
+	if queryParameter != "" {
+		return r.URL.Query().Get(queryParameter)
+	}
+	return ""
+}
+
+type basicUser struct {
+	hash      []byte
+	principal *Principal
+}
+
+type BasicAuthenticator struct {
+	Realm string
+	users map[string]basicUser
+}
+
+func NewBasicAuthenticator(realm string) *BasicAuthenticator {
+	return &BasicAuthenticator{Realm: realm, users: make(map[string]basicUser)}
+}
+
+// AddUser registers a user with a bcrypt hash of their password. If principal
+// is nil the user is identified by their username.
+func (a *BasicAuthenticator) AddUser(username, bcryptHash string, principal *Principal) {
+	if principal == nil {
+		principal = &Principal{Id: username}
+	}
+	a.users[username] = basicUser{hash: []byte(bcryptHash), principal: principal}
+}
+
+// Unknown usernames are checked against a dummy hash so that they take as
+// long to reject as wrong passwords.
+var (
+	dummyBcryptHash     []byte
+	dummyBcryptHashOnce sync.Once
+)
+
+func compareWithDummyHash(password string) {
+	dummyBcryptHashOnce.Do(func() {
+		dummyBcryptHash, _ = bcrypt.GenerateFromPassword([]byte("autorest"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(password))
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	user, ok := a.users[username]
+	if !ok {
+		compareWithDummyHash(password)
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user.principal, nil
+}
+
+func (a *BasicAuthenticator) Challenge() string {
+	return "Basic realm=\"" + a.Realm + "\", charset=\"UTF-8\""
+}
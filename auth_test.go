@@ -0,0 +1,130 @@
+package autorest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(data []byte) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("could not marshal claims: %s", err)
+	}
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return data + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(data)))
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator("X-API-Key", "api_key")
+	authenticator.AddKey("secret", &Principal{Id: "service"})
+	r := httptest.NewRequest("GET", "/rest/users", nil)
+	if principal, err := authenticator.Authenticate(r); principal != nil || err != nil {
+		t.Errorf("Expected no principal and no error without a key but got %v, %v", principal, err)
+	}
+	r.Header.Set("X-API-Key", "secret")
+	if principal, err := authenticator.Authenticate(r); err != nil || principal.Id != "service" {
+		t.Errorf("Expected the service principal but got %v, %v", principal, err)
+	}
+	r = httptest.NewRequest("GET", "/rest/users?api_key=wrong", nil)
+	if _, err := authenticator.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials but got %v", err)
+	}
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	authenticator := NewBasicAuthenticator("autorest")
+	authenticator.AddUser("alice", string(hash), nil)
+	r := httptest.NewRequest("GET", "/rest/users", nil)
+	r.SetBasicAuth("alice", "password")
+	if principal, err := authenticator.Authenticate(r); err != nil || principal.Id != "alice" {
+		t.Errorf("Expected alice but got %v, %v", principal, err)
+	}
+	r.SetBasicAuth("alice", "wrong")
+	if _, err := authenticator.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials but got %v", err)
+	}
+	r.SetBasicAuth("bob", "password")
+	if _, err := authenticator.Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials but got %v", err)
+	}
+}
+
+func TestHMACJWTAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	authenticator := NewHMACJWTAuthenticator(secret)
+	token := signJWT(t, "HS256", map[string]interface{}{"sub": "alice", "roles": []string{"editor"}, "exp": time.Now().Add(time.Hour).Unix()}, sign)
+	r := httptest.NewRequest("GET", "/rest/users", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	principal, err := authenticator.Authenticate(r)
+	if err != nil || principal.Id != "alice" || !principal.HasRole("editor") {
+		t.Errorf("Expected alice with the editor role but got %v, %v", principal, err)
+	}
+	expired := signJWT(t, "HS256", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}, sign)
+	r.Header.Set("Authorization", "Bearer "+expired)
+	if _, err := authenticator.Authenticate(r); err == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+	forged := signJWT(t, "HS256", map[string]interface{}{"sub": "mallory"}, func(data []byte) []byte { return []byte("forged") })
+	r.Header.Set("Authorization", "Bearer "+forged)
+	if _, err := authenticator.Authenticate(r); err == nil {
+		t.Error("Expected a token with a bad signature to be rejected")
+	}
+}
+
+func TestRSAJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	authenticator := NewRSAJWTAuthenticator(&key.PublicKey)
+	token := signJWT(t, "RS256", map[string]interface{}{"sub": "alice"}, func(data []byte) []byte {
+		hash := sha256.Sum256(data)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		return signature
+	})
+	r := httptest.NewRequest("GET", "/rest/users", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if principal, err := authenticator.Authenticate(r); err != nil || principal.Id != "alice" {
+		t.Errorf("Expected alice but got %v, %v", principal, err)
+	}
+	hmacToken := signJWT(t, "HS256", map[string]interface{}{"sub": "mallory"}, func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte("guess"))
+		mac.Write(data)
+		return mac.Sum(nil)
+	})
+	r.Header.Set("Authorization", "Bearer "+hmacToken)
+	if _, err := authenticator.Authenticate(r); err == nil {
+		t.Error("Expected an HMAC token to be rejected by an RSA authenticator")
+	}
+}
+
+func TestUnauthenticatedRequestIsChallenged(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.AddAuthenticator(NewBasicAuthenticator("autorest"))
+	w := httptest.NewRecorder()
+	server.handleAutorestRequest(w, httptest.NewRequest("GET", "/rest/users", nil))
+	if w.Code != UNAUTHORIZED {
+		t.Errorf("Expected status code %d but got %d", UNAUTHORIZED, w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); challenge == "" {
+		t.Error("Expected a WWW-Authenticate header")
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
@@ -1,22 +1,56 @@
 package autorest
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Server struct {
 	handler *Handler
 	logger *logger
+	isPrivileged func(*http.Request) bool
+	authenticators []Authenticator
+	rateLimiter *rateLimiter
+	cors *CORSOptions
+	mux *http.ServeMux
+	prefix string
+	routes sync.Once
+	middleware []func(http.Handler) http.Handler
+	chain http.Handler
+	openAPITitle string
+	openAPIVersion string
+	encoders map[string]Encoder
+	formats []string
+
+	mutex sync.Mutex
+	httpServer *http.Server
+	readTimeout time.Duration
+	writeTimeout time.Duration
+	idleTimeout time.Duration
 }
 
 func NewServer(credentials DatabaseCredentials) *Server {
-	s := &Server{}
-	s.handler = NewHandler(credentials)
-	s.logger = &logger{level: NONE}
-	s.handler.logger = s.logger
+	handler := NewHandler(credentials)
+	handler.logger = &logger{level: NONE}
+	return newServer(handler)
+}
+
+func newServer(handler *Handler) *Server {
+	s := &Server{
+		handler: handler,
+		logger: handler.logger,
+		rateLimiter: newRateLimiter(),
+		mux: http.NewServeMux(),
+		prefix: "/rest/",
+	}
+	s.registerDefaultEncoders()
 	return s
 }
 
@@ -29,47 +63,193 @@ func (s *Server) TurnOffLogging() {
 	s.logger.level = NONE
 }
 
-func (s *Server) Run(address string) {
-	http.HandleFunc("/rest/", s.handleAutorestRequest)
+// Run serves requests until Shutdown is called, in which case it returns nil.
+func (s *Server) Run(address string) error {
 	s.logger.Info("Starting server on " + address)
-	panic(http.ListenAndServe(address, nil))
+	return ignoreServerClosed(s.newHTTPServer(address).ListenAndServe())
 }
 
-func (s *Server) RunTLS(address, certFile, keyFile string) {
-	http.HandleFunc("/rest/", s.handleAutorestRequest)
+func (s *Server) RunTLS(address, certFile, keyFile string) error {
 	s.logger.Info("Starting server with TLS on " + address)
-	panic(http.ListenAndServeTLS(address, certFile, keyFile, nil))
+	return ignoreServerClosed(s.newHTTPServer(address).ListenAndServeTLS(certFile, keyFile))
+}
+
+func (s *Server) newHTTPServer(address string) *http.Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.httpServer = &http.Server{
+		Addr: address,
+		Handler: s,
+		ReadTimeout: s.readTimeout,
+		WriteTimeout: s.writeTimeout,
+		IdleTimeout: s.idleTimeout,
+	}
+	return s.httpServer
+}
+
+func ignoreServerClosed(err error) error {
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting new connections, waits for in-flight requests to
+// finish or for ctx to be done, and then closes the database connection.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	httpServer := s.httpServer
+	s.mutex.Unlock()
+	if httpServer != nil {
+		s.logger.Info("Shutting down server")
+		if err := httpServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return s.handler.db.Close()
+}
+
+// SetTimeouts configures the read, write and idle timeouts of the HTTP server
+// started by Run and RunTLS. Zero means no timeout.
+func (s *Server) SetTimeouts(read, write, idle time.Duration) {
+	s.readTimeout = read
+	s.writeTimeout = write
+	s.idleTimeout = idle
+}
+
+// SetQueryTimeout cancels queries on a table, or on all tables with
+// ALL_TABLES, that run longer than the timeout. They respond with 504.
+func (s *Server) SetQueryTimeout(tableName string, timeout time.Duration) {
+	if tableName == ALL_TABLES {
+		for _, table := range s.handler.tables {
+			table.QueryTimeout = timeout
+		}
+		return
+	}
+	s.handler.mustGetTable(tableName).QueryTimeout = timeout
+}
+
+// ServeHTTP makes the Server an http.Handler, so it can be mounted in another
+// router instead of being started with Run. The router must pass on the full
+// path, including the prefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.routes.Do(s.registerRoutes)
+	s.chain.ServeHTTP(w, s.withParsedRequest(r))
+}
+
+func (s *Server) registerRoutes() {
+	s.mux.Handle(s.prefix, s.withCORS(http.HandlerFunc(s.handleAutorestRequest)))
+	s.mux.Handle(s.prefix+"_openapi.json", s.withCORS(http.HandlerFunc(s.serveOpenAPI)))
+	s.mux.Handle(s.prefix+"_schema", s.withCORS(http.HandlerFunc(s.serveJSONSchema)))
+	s.mux.Handle(s.prefix+"_schema/", s.withCORS(http.HandlerFunc(s.serveJSONSchema)))
+	s.buildChain()
+}
+
+// SetPrefix changes the path the tables are served under from /rest/. It must
+// be called before the server starts handling requests.
+func (s *Server) SetPrefix(prefix string) {
+	s.prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if s.prefix == "//" {
+		s.prefix = "/"
+	}
 }
 
 func (s *Server) handleAutorestRequest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	request, err := parseRequest(r)
+	w.Header().Add("Vary", "Accept")
+	request, err := s.requestFor(r)
 	if err != nil {
-		statusCode, _ := strconv.Atoi(err.Error())
-		w.WriteHeader(statusCode)
-		w.Write([]byte("{\"message\":\"Server returned status code " + err.Error() + "\"}"))
+		s.respondWithError(err, w)
+		return
+	}
+	encoder, err := s.negotiateEncoder(r)
+	if err != nil && request.Action == DELETE {
+		encoder, err = s.encoders["json"], nil
+	}
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	r = withPrincipal(r, principal)
+	if err = s.rateLimiter.allow(r, request.Table, request.Action, w); err != nil {
+		s.respondWithError(err, w)
 		return
 	}
+	request.Principal = principal
+	request.privileged = s.isPrivileged != nil && s.isPrivileged(r)
+	stream := s.streamFor(&request, encoder, w)
+	if stream != nil {
+		request.stream = stream
+	}
 	result, err := s.handler.HandleRequest(request)
+	if stream != nil && stream.started() {
+		stream.end(err)
+		return
+	}
 	if err != nil {
-		statusCode, _ := strconv.Atoi(err.Error())
-		w.WriteHeader(statusCode)
-		w.Write([]byte("{\"message\":\"Server returned status code " + err.Error() + "\"}"))
+		s.respondWithError(err, w)
+		return
+	}
+	etag := s.handler.etagFor(request.Table, result)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	var table *Table
+	if request.Action != QUERY && request.Action != RPC {
+		table = s.handler.GetTable(request.Table)
+	}
+	switch request.Action {
+	case GET:
+		if etag != "" && request.IfNoneMatch != "" && etagMatches(request.IfNoneMatch, etag, true) {
+			w.WriteHeader(NOT_MODIFIED)
+			return
+		}
+		s.encode(OK, encoder, table, result, w)
+	case POST:
+		s.setLocation(request, result, w)
+		s.encode(CREATED, encoder, table, result, w)
+	case DELETE:
+		w.WriteHeader(NO_CONTENT)
+	default:
+		s.encode(OK, encoder, table, result, w)
+	}
+}
+
+func (s *Server) setLocation(r Request, result interface{}, w http.ResponseWriter) {
+	item, ok := result.(map[string]interface{})
+	if !ok {
 		return
 	}
-	s.respond(result, w)
+	table := s.handler.GetTable(r.Table)
+	if id, ok := item[table.PKColumn]; ok && id != nil {
+		w.Header().Set("Location", fmt.Sprintf("%s%s/%v", s.prefix, table.Name, id))
+	}
 }
 
-func (s *Server) respond(result interface{}, w http.ResponseWriter) {
+func (s *Server) respond(statusCode int, result interface{}, w http.ResponseWriter) {
 	response, err := json.Marshal(result)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("{\"message\":\"Server returned status code 500\"}"))
+		s.respondWithError(ApiError{INTERNAL_SERVER_ERROR}, w)
 		return
 	}
+	w.WriteHeader(statusCode)
 	w.Write(response)
 }
 
+func (s *Server) respondWithError(err error, w http.ResponseWriter) {
+	statusCode, _ := strconv.Atoi(err.Error())
+	if statusCode == UNAUTHORIZED {
+		s.setChallenges(w)
+	}
+	w.WriteHeader(statusCode)
+	w.Write([]byte("{\"message\":\"Server returned status code " + err.Error() + "\"}"))
+}
+
 func (s *Server) ExcludeTables(tables ...string) {
 	excludedTables := make(map[string]bool)
 	for _, table := range tables {
@@ -78,10 +258,77 @@ func (s *Server) ExcludeTables(tables ...string) {
 	s.handler.excludedTables = excludedTables
 }
 
+func (s *Server) SetVersionColumn(tableName, columnName string) {
+	table := s.handler.mustGetTable(tableName)
+	if !table.HasColumn(columnName) {
+		panic("Table " + tableName + " has no column " + columnName)
+	}
+	table.VersionColumn = columnName
+}
+
+func (s *Server) SoftDelete(tableName, columnName string) {
+	table := s.handler.mustGetTable(tableName)
+	if !table.HasColumn(columnName) {
+		panic("Table " + tableName + " has no column " + columnName)
+	}
+	table.SoftDeleteColumn = columnName
+}
+
+// SetPrivilegeCheck decides which callers may see soft-deleted rows with
+// include_deleted=true and restore them. By default nobody may.
+func (s *Server) SetPrivilegeCheck(isPrivileged func(*http.Request) bool) {
+	s.isPrivileged = isPrivileged
+}
+
+// SetAuditColumns names the columns of a table that autorest fills in on POST
+// and PUT. Any of the columns may be left empty.
+func (s *Server) SetAuditColumns(tableName string, columns AuditColumns) {
+	table := s.handler.mustGetTable(tableName)
+	for _, column := range []string{columns.CreatedAt, columns.UpdatedAt, columns.CreatedBy, columns.UpdatedBy} {
+		if column != "" && !table.HasColumn(column) {
+			panic("Table " + tableName + " has no column " + column)
+		}
+	}
+	table.AuditColumns = columns
+}
+
+// SetAuditColumnConvention applies the given column names to every table,
+// using whichever of the columns each table actually has.
+func (s *Server) SetAuditColumnConvention(columns AuditColumns) {
+	for _, table := range s.handler.tables {
+		table.AuditColumns = AuditColumns{
+			CreatedAt: columnIfPresent(table, columns.CreatedAt),
+			UpdatedAt: columnIfPresent(table, columns.UpdatedAt),
+			CreatedBy: columnIfPresent(table, columns.CreatedBy),
+			UpdatedBy: columnIfPresent(table, columns.UpdatedBy),
+		}
+	}
+}
+
+func columnIfPresent(table *Table, columnName string) string {
+	if table.HasColumn(columnName) {
+		return columnName
+	}
+	return ""
+}
+
+// EnableAuditLog records every write in the given table, in the same
+// transaction as the write itself. The table is not exposed as an endpoint.
+func (s *Server) EnableAuditLog(tableName string) {
+	table := s.handler.mustGetTable(tableName)
+	for _, column := range []string{"id", "table_name", "record_id", "action", "principal", "before_data", "after_data", "created_at"} {
+		if !table.HasColumn(column) {
+			panic("Audit table " + tableName + " has no column " + column)
+		}
+	}
+	s.handler.auditTable = tableName
+	s.handler.systemTables[tableName] = true
+}
+
 func (s *Server) ServeStaticFilesFromDirectory(directory string) {
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(directory))))
+	s.mux.Handle("/static/", s.withCORS(http.StripPrefix("/static/", http.FileServer(http.Dir(directory)))))
 }
 
 func (s *Server) RegisterHandler(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-	http.HandleFunc(pattern, handler)
+	s.mux.HandleFunc(pattern, handler)
 }
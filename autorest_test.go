@@ -0,0 +1,156 @@
+package autorest
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getServerForTesting(t *testing.T) (*Server, sqlmock.Sqlmock) {
+	handler, mock := getHandlerForTesting(t)
+	return newServer(handler), mock
+}
+
+func TestPostRespondsWithCreated(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	mock.ExpectPrepare("INSERT INTO products \\(name\\) VALUES \\(\\?\\)").
+		ExpectExec().
+		WithArgs("widget").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("widget"), nil))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rest/products", strings.NewReader("{\"name\":\"widget\"}"))
+	server.handleAutorestRequest(w, r)
+	if w.Code != CREATED {
+		t.Errorf("Expected status code %d but got %d", CREATED, w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/rest/products/7" {
+		t.Errorf("Expected Location /rest/products/7 but got %s", location)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestDeleteRespondsWithNoContent(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	mock.ExpectPrepare("DELETE FROM products WHERE id=\\?").
+		ExpectExec().
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	w := httptest.NewRecorder()
+	server.handleAutorestRequest(w, httptest.NewRequest("DELETE", "/rest/products/7", nil))
+	if w.Code != NO_CONTENT {
+		t.Errorf("Expected status code %d but got %d", NO_CONTENT, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected an empty body but got %s", w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestDeleteMissingRowRespondsWithNotFound(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	mock.ExpectPrepare("DELETE FROM products WHERE id=\\?").
+		ExpectExec().
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	w := httptest.NewRecorder()
+	server.handleAutorestRequest(w, httptest.NewRequest("DELETE", "/rest/products/7", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGetWithMatchingETagRespondsWithNotModified(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	for i := 0; i < 2; i++ {
+		mock.ExpectPrepare("SELECT \\* FROM orders WHERE id=\\?").
+			ExpectQuery().
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "total", "version"}).AddRow(1, []byte("10"), 3))
+	}
+	w := httptest.NewRecorder()
+	server.handleAutorestRequest(w, httptest.NewRequest("GET", "/rest/orders/1", nil))
+	etag := w.Header().Get("ETag")
+	if etag != "\"Mw\"" {
+		t.Errorf("Expected ETag \"Mw\" but got %s", etag)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/rest/orders/1", nil)
+	r.Header.Set("If-None-Match", etag)
+	server.handleAutorestRequest(w, r)
+	if w.Code != NOT_MODIFIED {
+		t.Errorf("Expected status code %d but got %d", NOT_MODIFIED, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestServeHTTPWithPrefix(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.SetPrefix("api/v1")
+	mock.ExpectPrepare("INSERT INTO products \\(name\\) VALUES \\(\\?\\)").
+		ExpectExec().
+		WithArgs("widget").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("widget"), nil))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/products", strings.NewReader("{\"name\":\"widget\"}")))
+	if w.Code != CREATED {
+		t.Errorf("Expected status code %d but got %d", CREATED, w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/api/v1/products/7" {
+		t.Errorf("Expected Location /api/v1/products/7 but got %s", location)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/products/7", nil))
+	if w.Code != NOT_FOUND {
+		t.Errorf("Expected status code %d but got %d", NOT_FOUND, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestServersDoNotShareRoutes(t *testing.T) {
+	first, _ := getServerForTesting(t)
+	second, _ := getServerForTesting(t)
+	first.RegisterHandler("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(OK)
+	})
+	second.RegisterHandler("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(NO_CONTENT)
+	})
+	w := httptest.NewRecorder()
+	first.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if w.Code != OK {
+		t.Errorf("Expected status code %d but got %d", OK, w.Code)
+	}
+	w = httptest.NewRecorder()
+	second.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if w.Code != NO_CONTENT {
+		t.Errorf("Expected status code %d but got %d", NO_CONTENT, w.Code)
+	}
+	cleanUp(first.handler)
+	cleanUp(second.handler)
+}
+
+func TestShutdownClosesDatabase(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	mock.ExpectClose()
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+}
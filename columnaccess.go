@@ -0,0 +1,105 @@
+package autorest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnAccess restricts what callers can do with a column. Hidden columns are
+// never selected or returned, read-only columns are ignored on POST and PUT,
+// write-once columns are ignored on PUT, and masked columns are returned
+// through Mask. None of the restrictions apply to callers with one of the
+// ExemptRoles.
+type ColumnAccess struct {
+	Hidden      bool
+	ReadOnly    bool
+	WriteOnce   bool
+	Mask        func(value interface{}) interface{}
+	ExemptRoles []string
+}
+
+func (s *Server) SetColumnAccess(tableName, columnName string, access ColumnAccess) {
+	column := s.handler.mustGetTable(tableName).GetColumn(columnName)
+	if column == nil {
+		panic("Table " + tableName + " has no column " + columnName)
+	}
+	column.Access = access
+}
+
+// MaskAllButLast replaces all but the last n characters of a value with '*'.
+func MaskAllButLast(n int) func(value interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		if value == nil {
+			return nil
+		}
+		s := []rune(fmt.Sprint(value))
+		if len(s) <= n {
+			return string(s)
+		}
+		return strings.Repeat("*", len(s)-n) + string(s[len(s)-n:])
+	}
+}
+
+func (c *Column) restrictedFor(principal *Principal) bool {
+	for _, role := range c.Access.ExemptRoles {
+		if principal.HasRole(role) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Column) isHiddenFor(principal *Principal) bool {
+	return c.Access.Hidden && c.restrictedFor(principal)
+}
+
+func (c *Column) isWritableFor(principal *Principal, action int) bool {
+	if !c.Access.ReadOnly && !(c.Access.WriteOnce && action != POST) {
+		return true
+	}
+	return !c.restrictedFor(principal)
+}
+
+// isFilterableFor reports whether a caller may filter and sort by a column.
+// Masked columns can't be, since matching a value one character at a time
+// would reveal it.
+func (c *Column) isFilterableFor(principal *Principal) bool {
+	return !c.isHiddenFor(principal) && !(c.Access.Mask != nil && c.restrictedFor(principal))
+}
+
+func (t *Table) isFilterableColumn(colName string, principal *Principal) bool {
+	column := t.GetColumn(colName)
+	return column != nil && column.isFilterableFor(principal)
+}
+
+// hasRestrictedColumnsFor reports whether any column of the table is hidden
+// from or masked for a caller.
+func (t *Table) hasRestrictedColumnsFor(principal *Principal) bool {
+	for _, column := range t.Columns {
+		if (column.Access.Hidden || column.Access.Mask != nil) && column.restrictedFor(principal) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) isWritableColumn(colName string, principal *Principal, action int) bool {
+	column := t.GetColumn(colName)
+	return column != nil && column.isWritableFor(principal, action) && !t.isManagedColumn(colName)
+}
+
+func (h *Handler) maskColumns(r Request, table *Table, rows []map[string]interface{}) {
+	if r.unrestricted {
+		return
+	}
+	for _, column := range table.Columns {
+		if column.Access.Mask == nil || !column.restrictedFor(r.Principal) {
+			continue
+		}
+		for _, row := range rows {
+			if value, ok := row[column.Name]; ok {
+				row[column.Name] = column.Access.Mask(value)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package autorest
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"strings"
+	"testing"
+)
+
+func getHandlerWithColumnAccessForTesting(t *testing.T) (*Handler, sqlmock.Sqlmock) {
+	handler, mock := getHandlerForTesting(t)
+	handler.tables["members"] = &Table{
+		Name:     "members",
+		PKColumn: "id",
+		Columns: []*Column{
+			&Column{Name: "id", Type: "int"},
+			&Column{Name: "email", Type: "varchar", Access: ColumnAccess{WriteOnce: true}},
+			&Column{Name: "password_hash", Type: "varchar", Access: ColumnAccess{Hidden: true}},
+			&Column{Name: "is_admin", Type: "tinyint", Access: ColumnAccess{ReadOnly: true, ExemptRoles: []string{"admin"}}},
+			&Column{Name: "ssn", Type: "varchar", Access: ColumnAccess{Mask: MaskAllButLast(4)}},
+		},
+	}
+	return handler, mock
+}
+
+func TestHiddenColumnsAreNotSelected(t *testing.T) {
+	handler, mock := getHandlerWithColumnAccessForTesting(t)
+	r := Request{Table: "members", Action: GET_ALL, QueryParameters: map[string]interface{}{"password_hash": "x", "sort": "password_hash,-id"}}
+	mock.ExpectPrepare("^SELECT id,email,is_admin,ssn FROM members ORDER BY id DESC$").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), 0, []byte("123456789")))
+	rawResult, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkKeyAndValue(t, "ssn", "*****6789", rawResult.([]map[string]interface{})[0])
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestMaskedColumnsCannotBeFilteredOrSorted(t *testing.T) {
+	handler, _ := getHandlerWithColumnAccessForTesting(t)
+	r := Request{Table: "members", Action: GET_ALL, QueryParameters: map[string]interface{}{"ssn": "123-45", "sort": "ssn"}}
+	query, values := handler.queryBuilder.BuildSelectAllQuery(r, handler.GetTable("members"))
+	if query != "SELECT id,email,is_admin,ssn FROM members" || len(values) != 0 {
+		t.Errorf("Expected the masked column to be ignored but got %s %v", query, values)
+	}
+	for _, argument := range graphQLListField("members", "members", handler.GetTable("members"), nil, "").arguments {
+		if argument.name == "ssn" {
+			t.Error("Expected no GraphQL argument for the masked column")
+		}
+	}
+	cleanUp(handler)
+}
+
+func TestReadOnlyAndWriteOnceColumnsAreIgnoredOnPut(t *testing.T) {
+	handler, mock := getHandlerWithColumnAccessForTesting(t)
+	data := map[string]interface{}{"email": "new@b.c", "is_admin": 1, "ssn": "987654321"}
+	r := Request{Table: "members", Action: PUT, Id: 1, Data: data}
+	mock.ExpectPrepare("^UPDATE members SET ssn=\\? WHERE id=\\?$").
+		ExpectExec().
+		WithArgs("987654321", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT id,email,is_admin,ssn FROM members WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), 0, []byte("987654321")))
+	if _, err := handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestExemptRolesMayWriteReadOnlyColumns(t *testing.T) {
+	handler, mock := getHandlerWithColumnAccessForTesting(t)
+	data := map[string]interface{}{"email": "a@b.c", "is_admin": 1}
+	r := Request{Table: "members", Action: POST, Data: data, Principal: &Principal{Id: "root", Roles: []string{"admin"}}}
+	mock.ExpectPrepare("^INSERT INTO members \\(email,is_admin\\) VALUES \\(\\?,\\?\\)$").
+		ExpectExec().
+		WithArgs("a@b.c", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare("SELECT id,email,is_admin,ssn FROM members WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), 1, nil))
+	if _, err := handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+type capturedArgument struct {
+	value *string
+}
+
+func (c capturedArgument) Match(value driver.Value) bool {
+	*c.value, _ = value.(string)
+	return true
+}
+
+func TestHistoryAppliesColumnAccessOfReader(t *testing.T) {
+	handler, mock := getHandlerWithColumnAccessForTesting(t)
+	handler.auditTable = "audit_log"
+	handler.GetTable("members").GetColumn("password_hash").Access.ExemptRoles = []string{"admin"}
+	admin := &Principal{Id: "root", Roles: []string{"admin"}}
+	var afterData string
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO members \\(email,password_hash,ssn\\) VALUES \\(\\?,\\?,\\?\\)").
+		ExpectExec().
+		WithArgs("a@b.c", "secret", "123456789").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare("SELECT \\* FROM members WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), []byte("secret"), 0, []byte("123456789")))
+	mock.ExpectPrepare("SELECT \\* FROM members WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), []byte("secret"), 0, []byte("123456789")))
+	mock.ExpectPrepare("INSERT INTO audit_log").
+		ExpectExec().
+		WithArgs("members", 1, "POST", "root", nil, capturedArgument{&afterData}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	data := map[string]interface{}{"email": "a@b.c", "password_hash": "secret", "ssn": "123456789"}
+	if _, err := handler.HandleRequest(Request{Table: "members", Action: POST, Data: data, Principal: admin}); err != nil {
+		t.Fatalf("An unexpected error occurred: %s", err)
+	}
+	if !strings.Contains(afterData, "secret") {
+		t.Fatalf("Expected the snapshot to hold every column but got %s", afterData)
+	}
+	mock.ExpectPrepare("SELECT (.+) FROM audit_log").
+		ExpectQuery().
+		WithArgs("members", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "principal", "before_data", "after_data", "created_at"}).
+			AddRow([]byte("POST"), []byte("root"), nil, []byte(afterData), []byte("2020-01-01 00:00:00")))
+	rawResult, err := handler.HandleRequest(Request{Table: "members", Action: HISTORY, Id: 1})
+	if err != nil {
+		t.Fatalf("An unexpected error occurred: %s", err)
+	}
+	expected := `{"email":"a@b.c","id":1,"is_admin":0,"ssn":"*****6789"}`
+	if after := string(rawResult.([]map[string]interface{})[0]["after_data"].(json.RawMessage)); after != expected {
+		t.Errorf("Expected %s but got %s", expected, after)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestAuditSnapshotsIgnoreColumnAccessOfWriter(t *testing.T) {
+	handler, mock := getHandlerWithColumnAccessForTesting(t)
+	handler.auditTable = "audit_log"
+	var beforeData, afterData string
+	columns := []string{"id", "email", "password_hash", "is_admin", "ssn"}
+	mock.ExpectBegin()
+	mock.ExpectPrepare("^SELECT \\* FROM members WHERE id=\\?$").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, []byte("a@b.c"), []byte("hash"), 0, []byte("123456789")))
+	mock.ExpectPrepare("^UPDATE members SET ssn=\\? WHERE id=\\?$").
+		ExpectExec().
+		WithArgs("987654321", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("^SELECT id,email,is_admin,ssn FROM members WHERE id=\\?$").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "is_admin", "ssn"}).AddRow(1, []byte("a@b.c"), 0, []byte("987654321")))
+	mock.ExpectPrepare("^SELECT \\* FROM members WHERE id=\\?$").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, []byte("a@b.c"), []byte("hash"), 0, []byte("987654321")))
+	mock.ExpectPrepare("INSERT INTO audit_log").
+		ExpectExec().
+		WithArgs("members", 1, "PUT", nil, capturedArgument{&beforeData}, capturedArgument{&afterData}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	r := Request{Table: "members", Action: PUT, Id: 1, Data: map[string]interface{}{"ssn": "987654321"}}
+	rawResult, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Fatalf("An unexpected error occurred: %s", err)
+	}
+	checkKeyAndValue(t, "ssn", "*****4321", rawResult.(map[string]interface{}))
+	if !strings.Contains(beforeData, `"password_hash":"hash"`) || !strings.Contains(beforeData, `"ssn":"123456789"`) {
+		t.Errorf("Expected the before snapshot to hold every column unmasked but got %s", beforeData)
+	}
+	if !strings.Contains(afterData, `"password_hash":"hash"`) || !strings.Contains(afterData, `"ssn":"987654321"`) {
+		t.Errorf("Expected the after snapshot to hold every column unmasked but got %s", afterData)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
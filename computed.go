@@ -0,0 +1,73 @@
+package autorest
+
+// ComputeFunc derives a value from a row as it is returned to the client.
+type ComputeFunc func(row map[string]interface{}) interface{}
+
+// ComputedField is a field that is returned with every row of a table but
+// is not a column. Fields backed by a SQL expression are selected by the
+// database and can be filtered and sorted by, fields backed by a Func are
+// computed by autorest after the row has been read. Type is the MySQL data
+// type of the field, which is used in the schema documents if set.
+type ComputedField struct {
+	Name string
+	SQL  string
+	Func ComputeFunc
+	Type string
+}
+
+// ComputeSQL adds a field to a table whose value is the result of a SQL
+// expression, e.g. CONCAT(first_name,' ',last_name).
+func (s *Server) ComputeSQL(tableName, name, expression string) *ComputedField {
+	return s.addComputedField(tableName, &ComputedField{Name: name, SQL: expression})
+}
+
+// ComputeFunc adds a field to a table whose value is computed from the rest
+// of the row, after hidden columns have been left out and masks applied.
+func (s *Server) ComputeFunc(tableName, name string, compute ComputeFunc) *ComputedField {
+	return s.addComputedField(tableName, &ComputedField{Name: name, Func: compute})
+}
+
+func (s *Server) addComputedField(tableName string, field *ComputedField) *ComputedField {
+	table := s.handler.mustGetTable(tableName)
+	if !identifierPattern.MatchString(field.Name) {
+		panic("Invalid computed field name " + field.Name)
+	}
+	if table.HasColumn(field.Name) || table.GetComputedField(field.Name) != nil {
+		panic("Table " + tableName + " already has a field " + field.Name)
+	}
+	table.ComputedFields = append(table.ComputedFields, field)
+	return field
+}
+
+func (t *Table) GetComputedField(name string) *ComputedField {
+	for _, field := range t.ComputedFields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// filterExpression returns the SQL to filter or sort a table by, which is the
+// column itself or the expression of a computed field, or "" if the caller
+// may not filter by it.
+func (t *Table) filterExpression(name string, principal *Principal) string {
+	if t.isFilterableColumn(name, principal) {
+		return name
+	}
+	if field := t.GetComputedField(name); field != nil && field.SQL != "" {
+		return "(" + field.SQL + ")"
+	}
+	return ""
+}
+
+func (h *Handler) computeFields(table *Table, rows []map[string]interface{}) {
+	for _, field := range table.ComputedFields {
+		if field.Func == nil {
+			continue
+		}
+		for _, row := range rows {
+			row[field.Name] = field.Func(row)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"strings"
+	"testing"
+)
+
+func TestComputedSQLField(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.ComputeSQL("users", "full_name", "CONCAT(first_name,' ',last_name)")
+	r := Request{Table: "users", Action: GET_ALL, QueryParameters: map[string]interface{}{"full_name": "Ann", "sort": "-full_name"}}
+	mock.ExpectPrepare("SELECT \\*,\\(CONCAT\\(first_name,' ',last_name\\)\\) AS full_name FROM users WHERE \\(CONCAT\\(first_name,' ',last_name\\)\\) LIKE \\? ORDER BY full_name DESC").
+		ExpectQuery().
+		WithArgs("%Ann%").
+		WillReturnRows(sqlmock.NewRows(append(USERS_COLUMNS, "full_name")))
+	if _, err := server.handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestComputedFuncField(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.ComputeFunc("products", "slug", func(row map[string]interface{}) interface{} {
+		return strings.ToLower(row["name"].(string))
+	})
+	r := Request{Table: "products", Action: GET, Id: 1, QueryParameters: map[string]interface{}{"sort": "slug"}}
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, []byte("Widget")))
+	result, err := server.handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkKeyAndValue(t, "slug", "widget", result.(map[string]interface{}))
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestComputedFieldNameMustBeUnique(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a computed field named like a column")
+		}
+		cleanUp(server.handler)
+	}()
+	server.ComputeSQL("users", "age", "1")
+}
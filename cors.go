@@ -0,0 +1,124 @@
+package autorest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures cross-origin requests. Allowed origins may be "*"
+// or contain a single wildcard, e.g. "https://*.example.com". Empty method,
+// header and exposed header lists fall back to sensible defaults. To allow
+// credentials, origins must be listed explicitly or only have a wildcard for
+// subdomains, e.g. "https://*.example.com".
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+var (
+	defaultCORSMethods        = []string{"GET", "POST", "PUT", "DELETE"}
+	defaultCORSHeaders        = []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "X-API-Key"}
+	defaultCORSExposedHeaders = []string{"ETag", "Location", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+)
+
+func (s *Server) EnableCORS(options CORSOptions) {
+	if options.AllowCredentials {
+		for _, origin := range options.AllowedOrigins {
+			if strings.Contains(origin, "*") && !isSubdomainPattern(origin) {
+				panic("CORS credentials can only be allowed for listed origins or subdomains, not " + origin)
+			}
+		}
+	}
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = defaultCORSMethods
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = defaultCORSHeaders
+	}
+	if len(options.ExposedHeaders) == 0 {
+		options.ExposedHeaders = defaultCORSExposedHeaders
+	}
+	s.cors = &options
+}
+
+func (o *CORSOptions) allowsOrigin(origin string) bool {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSubdomainPattern reports whether an origin pattern only has a wildcard
+// for the subdomains of a domain, e.g. "https://*.example.com", rather than
+// matching hosts under different domains like "https://*" or "*.com".
+func isSubdomainPattern(pattern string) bool {
+	i := strings.Index(pattern, "://*.")
+	if i <= 0 {
+		return false
+	}
+	domain := pattern[i+len("://*."):]
+	host := strings.Split(domain, ":")[0]
+	return !strings.ContainsAny(domain, "*/") && strings.Contains(host, ".") && !strings.HasPrefix(host, ".") && !strings.HasSuffix(host, ".")
+}
+
+func (o *CORSOptions) allowsAnyOrigin() bool {
+	return len(o.AllowedOrigins) == 1 && o.AllowedOrigins[0] == "*"
+}
+
+// withCORS answers preflight requests and adds CORS headers to responses for
+// allowed origins. Preflights are answered before authentication, since
+// browsers send them without credentials.
+func (s *Server) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if s.cors == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		isPreflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !s.cors.allowsOrigin(origin) {
+			if isPreflight {
+				w.WriteHeader(FORBIDDEN)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.cors.allowsAnyOrigin() {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if s.cors.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !isPreflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(s.cors.ExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(s.cors.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowedHeaders, ", "))
+		if s.cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(NO_CONTENT)
+	})
+}
@@ -0,0 +1,79 @@
+package autorest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.EnableCORS(CORSOptions{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true, MaxAge: time.Hour})
+	handler := server.withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Preflight requests should not reach the handler")
+	}))
+	r := httptest.NewRequest("OPTIONS", "/rest/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != NO_CONTENT {
+		t.Errorf("Expected status code %d but got %d", NO_CONTENT, w.Code)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Expected the origin to be allowed but got %s", origin)
+	}
+	if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "true" {
+		t.Errorf("Expected credentials to be allowed but got %s", credentials)
+	}
+	if maxAge := w.Header().Get("Access-Control-Max-Age"); maxAge != "3600" {
+		t.Errorf("Expected max age 3600 but got %s", maxAge)
+	}
+	r.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Expected the origin not to be allowed but got %s", origin)
+	}
+	cleanUp(server.handler)
+}
+
+func TestCORSCredentialsRequireListedOrigins(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	for _, origin := range []string{"*", "https://*", "*.com", "https://*.com", "https://*example.com", "https://*.example.*"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for credentials with %s", origin)
+				}
+			}()
+			server.EnableCORS(CORSOptions{AllowedOrigins: []string{"https://app.example.com", origin}, AllowCredentials: true})
+		}()
+	}
+	server.EnableCORS(CORSOptions{AllowedOrigins: []string{"https://app.example.com", "https://*.example.com", "http://*.example.com:8080"}, AllowCredentials: true})
+	cleanUp(server.handler)
+}
+
+func TestCORSDecoratesResponses(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.EnableCORS(CORSOptions{AllowedOrigins: []string{"*"}})
+	called := false
+	handler := server.withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	r := httptest.NewRequest("GET", "/static/index.html", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if !called {
+		t.Error("Expected the request to reach the handler")
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Expected any origin to be allowed but got %s", origin)
+	}
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); exposed == "" {
+		t.Error("Expected exposed headers")
+	}
+	cleanUp(server.handler)
+}
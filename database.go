@@ -3,6 +3,7 @@ package autorest
 import (
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
+	"time"
 )
 
 const (
@@ -12,23 +13,65 @@ const (
 type QueryBuilder interface {
 	CreateDSN(credentials DatabaseCredentials) string
 	ParseSchema(db *sql.DB) DatabaseSchema
-	BuildSelectQuery(table *Table) string
-	BuildSelectAllQuery(r request, table *Table) (string, []interface{})
-	BuildPOSTQueryAndValues(r request, t *Table) (string, []interface{})
-	BuildPUTQueryAndValues(r request, t *Table) (string, []interface{})
-	BuildDeleteQuery(table *Table) string
+	ParseRoutines(db *sql.DB) map[string]*Routine
+	BuildSelectQuery(r Request, table *Table) (string, []interface{})
+	BuildSelectAllQuery(r Request, table *Table) (string, []interface{})
+	BuildPOSTQueryAndValues(r Request, t *Table) (string, []interface{})
+	BuildPUTQueryAndValues(r Request, t *Table) (string, []interface{}, error)
+	BuildDeleteQuery(r Request, table *Table) (string, []interface{})
+	BuildRestoreQuery(r Request, table *Table) (string, []interface{})
+	BuildAuditInsertQuery(auditTable string) string
+	BuildHistoryQuery(auditTable string) string
+	BuildAPIKeyLookupQuery(keyTable string) string
+	BuildAPIKeyListQuery(keyTable string) string
+	BuildAPIKeyInsertQuery(keyTable string) string
+	BuildAPIKeyRevokeQuery(keyTable string) string
+	BuildAPIKeyRotateQuery(keyTable string) string
+	BuildPaginatedQuery(query string, values []interface{}, limit, offset int64) (string, []interface{})
+	BuildFunctionCallQuery(routine *Routine) string
+	BuildCallQuery(routine *Routine, variables []string) string
+	BuildSetVariableQuery(variable string) string
+	BuildSelectVariablesQuery(variables []string) string
 }
 
 type DatabaseSchema map[string]*Table
 
 type Table struct {
-	Name     string
-	Columns  []*Column
-	PKColumn string
+	Name             string
+	Columns          []*Column
+	PKColumn         string
+	VersionColumn    string
+	SoftDeleteColumn string
+	AuditColumns     AuditColumns
+	RowFilters       []RowFilter
+	QueryTimeout     time.Duration
+	ComputedFields   []*ComputedField
+	ForeignKeys      []ForeignKey
+	Stream           *StreamOptions
+}
+
+type ForeignKey struct {
+	Column           string
+	ReferencedTable  string
+	ReferencedColumn string
+}
+
+type AuditColumns struct {
+	CreatedAt string
+	UpdatedAt string
+	CreatedBy string
+	UpdatedBy string
 }
 
 type Column struct {
-	Name string
+	Name          string
+	Type          string
+	Nullable      bool
+	MaxLength     int64
+	Enum          []string
+	AutoIncrement bool
+	HasDefault    bool
+	Access        ColumnAccess
 }
 
 func (t *Table) HasColumn(colName string) bool {
@@ -40,6 +83,48 @@ func (t *Table) HasColumn(colName string) bool {
 	return false
 }
 
+// isManagedColumn reports whether a column is maintained by autorest, in which
+// case values supplied by clients are ignored.
+func (t *Table) isManagedColumn(colName string) bool {
+	switch colName {
+	case "":
+		return false
+	case t.VersionColumn, t.SoftDeleteColumn:
+		return true
+	case t.AuditColumns.CreatedAt, t.AuditColumns.UpdatedAt, t.AuditColumns.CreatedBy, t.AuditColumns.UpdatedBy:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *Table) GetColumn(colName string) *Column {
+	for _, col := range t.Columns {
+		if col.Name == colName {
+			return col
+		}
+	}
+	return nil
+}
+
+func (c *Column) IsInteger() bool {
+	switch c.Type {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Column) IsTemporal() bool {
+	switch c.Type {
+	case "date", "datetime", "timestamp":
+		return true
+	default:
+		return false
+	}
+}
+
 type DatabaseCredentials struct {
 	Name     string
 	Username string
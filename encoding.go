@@ -0,0 +1,389 @@
+package autorest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder writes the result of a request in a format, e.g. CSV. Table is
+// the table the request was for, or nil for named queries and routines.
+// Results are single rows (map[string]interface{}), lists of rows
+// ([]map[string]interface{}) or, for overrides and routines, any value
+// encoding/json can marshal.
+type Encoder interface {
+	ContentType() string
+	Encode(w io.Writer, table *Table, result interface{}) error
+}
+
+// RegisterEncoder makes a format available to clients, through the format
+// query parameter, e.g. ?format=csv, or an Accept header with the encoder's
+// content type. It replaces any encoder registered for the same format.
+func (s *Server) RegisterEncoder(format string, encoder Encoder) {
+	if !identifierPattern.MatchString(format) {
+		panic("Invalid format name " + format)
+	}
+	for i, registered := range s.formats {
+		if registered == format {
+			s.formats = append(s.formats[:i], s.formats[i+1:]...)
+			break
+		}
+	}
+	s.formats = append(s.formats, format)
+	s.encoders[format] = encoder
+}
+
+func (s *Server) registerDefaultEncoders() {
+	s.encoders = make(map[string]Encoder)
+	s.RegisterEncoder("json", JSONEncoder{})
+	s.RegisterEncoder("ndjson", NDJSONEncoder{})
+	s.RegisterEncoder("csv", CSVEncoder{})
+	s.RegisterEncoder("xml", XMLEncoder{})
+}
+
+// negotiateEncoder picks the encoder for a request, from the format query
+// parameter if there is one and otherwise from the Accept header. Requests
+// without either get JSON, as do ties between formats and headers whose most
+// preferred types aren't available but which accept JSON, such as a
+// browser's text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8.
+func (s *Server) negotiateEncoder(r *http.Request) (Encoder, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if encoder, ok := s.encoders[format]; ok {
+			return encoder, nil
+		}
+		return nil, ApiError{NOT_ACCEPTABLE}
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return s.encoders["json"], nil
+	}
+	var best Encoder
+	bestQuality, bestSpecificity := 0.0, -1
+	topQuality, jsonQuality := 0.0, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, parameters, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := parameters["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		if quality > topQuality {
+			topQuality = quality
+		}
+		for _, format := range s.formats {
+			encoder := s.encoders[format]
+			specificity := mediaRangeSpecificity(mediaType, encoder.ContentType())
+			if specificity < 0 {
+				continue
+			}
+			if format == "json" && quality > jsonQuality {
+				jsonQuality = quality
+			}
+			if quality > bestQuality || (quality == bestQuality && (specificity > bestSpecificity || specificity == bestSpecificity && format == "json")) {
+				best, bestQuality, bestSpecificity = encoder, quality, specificity
+			}
+		}
+	}
+	if best == nil {
+		return nil, ApiError{NOT_ACCEPTABLE}
+	}
+	if bestQuality < topQuality && jsonQuality > 0 {
+		return s.encoders["json"], nil
+	}
+	return best, nil
+}
+
+// mediaRangeSpecificity returns -1 if a media range of an Accept header does
+// not match a content type, and otherwise how specific the match is, so that
+// text/csv is preferred over text/* and */*.
+func mediaRangeSpecificity(mediaRange, contentType string) int {
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	switch {
+	case mediaRange == contentType:
+		return 2
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	case mediaRange == "*/*":
+		return 0
+	default:
+		return -1
+	}
+}
+
+// encode writes a result with an encoder, falling back to a JSON error if
+// the encoder fails before anything has been written.
+func (s *Server) encode(statusCode int, encoder Encoder, table *Table, result interface{}, w http.ResponseWriter) {
+	var buffer bytes.Buffer
+	if err := encoder.Encode(&buffer, table, result); err != nil {
+		s.logger.Error(err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		s.respondWithError(ApiError{INTERNAL_SERVER_ERROR}, w)
+		return
+	}
+	w.Header().Set("Content-Type", encoder.ContentType())
+	w.WriteHeader(statusCode)
+	w.Write(buffer.Bytes())
+}
+
+type JSONEncoder struct{}
+
+func (JSONEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (JSONEncoder) Encode(w io.Writer, table *Table, result interface{}) error {
+	response, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(response)
+	return err
+}
+
+// NDJSONEncoder writes one JSON object per line, one for each row of a list.
+type NDJSONEncoder struct{}
+
+func (NDJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (NDJSONEncoder) Encode(w io.Writer, table *Table, result interface{}) error {
+	encoder := json.NewEncoder(w)
+	if rows, ok := result.([]map[string]interface{}); ok {
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return encoder.Encode(result)
+}
+
+// CSVEncoder writes a header row followed by a line per row. Columns are in
+// the order of the table's schema, followed by its computed fields and then
+// any other fields in alphabetical order. Nested values are written as JSON.
+type CSVEncoder struct{}
+
+func (CSVEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (CSVEncoder) Encode(w io.Writer, table *Table, result interface{}) error {
+	rows := resultRows(result)
+	columns := fieldOrder(table, rows)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			value, err := textValue(row[column])
+			if err != nil {
+				return err
+			}
+			record[i] = value
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// XMLEncoder writes lists as <rows><row>...</row></rows> and single rows as
+// <row>...</row>, with an element per field in the same order as CSV. Null
+// values are written as empty elements. Fields whose names aren't valid XML
+// names are written as <field name="...">.
+type XMLEncoder struct{}
+
+func (XMLEncoder) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (XMLEncoder) Encode(w io.Writer, table *Table, result interface{}) error {
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	rows, isList := result.([]map[string]interface{})
+	if !isList {
+		rows = resultRows(result)
+		if len(rows) == 0 {
+			buffer.WriteString("<row/>")
+		}
+	} else {
+		buffer.WriteString("<rows>")
+	}
+	columns := fieldOrder(table, rows)
+	for _, row := range rows {
+		buffer.WriteString("<row>")
+		for _, column := range columns {
+			if err := writeXMLElement(&buffer, column, row[column]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("</row>")
+	}
+	if isList {
+		buffer.WriteString("</rows>")
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+func writeXMLElement(buffer *bytes.Buffer, name string, value interface{}) error {
+	open, end := name, name
+	if !isXMLName(name) {
+		var attribute bytes.Buffer
+		xml.EscapeText(&attribute, []byte(name))
+		open, end = "field name=\""+attribute.String()+"\"", "field"
+	}
+	if value == nil {
+		buffer.WriteString("<" + open + "/>")
+		return nil
+	}
+	buffer.WriteString("<" + open + ">")
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if err := writeXMLElement(buffer, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := writeXMLElement(buffer, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		text, err := textValue(value)
+		if err != nil {
+			return err
+		}
+		if err = xml.EscapeText(buffer, []byte(text)); err != nil {
+			return err
+		}
+	}
+	buffer.WriteString("</" + end + ">")
+	return nil
+}
+
+// isXMLName returns whether a field can be used as an element name, following
+// the Name production of XML 1.0 without colons, which are namespace
+// separators.
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isXMLNameStartChar(r) && (i == 0 || !isXMLNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isXMLNameStartChar(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r == '_' || r >= 'a' && r <= 'z' ||
+		r >= 0xC0 && r <= 0xD6 || r >= 0xD8 && r <= 0xF6 || r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D || r >= 0x37F && r <= 0x1FFF || r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F || r >= 0x2C00 && r <= 0x2FEF || r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF || r >= 0xFDF0 && r <= 0xFFFD || r >= 0x10000 && r <= 0xEFFFF
+}
+
+func isXMLNameChar(r rune) bool {
+	return r == '-' || r == '.' || r >= '0' && r <= '9' || r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F || r >= 0x203F && r <= 0x2040
+}
+
+// resultRows returns the rows of a result, treating a single row or any
+// other value as a list of one.
+func resultRows(result interface{}) []map[string]interface{} {
+	switch v := result.(type) {
+	case nil:
+		return []map[string]interface{}{}
+	case []map[string]interface{}:
+		return v
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	default:
+		return []map[string]interface{}{{"result": v}}
+	}
+}
+
+// fieldOrder returns the fields of rows in the order of the table's schema.
+func fieldOrder(table *Table, rows []map[string]interface{}) []string {
+	fields := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string, always bool) {
+		if seen[name] {
+			return
+		}
+		present := always && len(rows) == 0
+		for _, row := range rows {
+			if _, ok := row[name]; ok {
+				present = true
+				break
+			}
+		}
+		if present {
+			fields = append(fields, name)
+			seen[name] = true
+		}
+	}
+	if table != nil {
+		for _, column := range table.Columns {
+			add(column.Name, !column.Access.Hidden)
+		}
+		for _, field := range table.ComputedFields {
+			add(field.Name, true)
+		}
+	}
+	others := make([]string, 0)
+	for _, row := range rows {
+		for name := range row {
+			if !seen[name] {
+				others = append(others, name)
+				seen[name] = true
+			}
+		}
+	}
+	sort.Strings(others)
+	return append(fields, others...)
+}
+
+// textValue formats a value for CSV and XML, writing nested values as JSON.
+func textValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32:
+		return fmt.Sprint(v), nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
@@ -0,0 +1,139 @@
+package autorest
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func getUsersAs(t *testing.T, server *Server, mock sqlmock.Sqlmock, target, accept string) *httptest.ResponseRecorder {
+	mock.ExpectPrepare("SELECT \\* FROM users").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"email_address", "age", "id", "last_name", "first_name"}).
+			AddRow([]byte("guy@somewhere.com"), 30, 1, []byte("Smith, Jr."), []byte("Al")).
+			AddRow(nil, 15, 2, []byte("<Doe>"), []byte("Jo")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", target, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func TestCSVFollowsSchemaColumnOrder(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	w := getUsersAs(t, server, mock, "/rest/users", "text/csv")
+	expected := "id,first_name,last_name,email_address,age\n1,Al,\"Smith, Jr.\",guy@somewhere.com,30\n2,Jo,<Doe>,,15\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Errorf("Expected a CSV content type but got %s", contentType)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestFormatParameterSelectsEncoder(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	w := getUsersAs(t, server, mock, "/rest/users?format=ndjson", "application/json")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "{") || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected two lines of NDJSON but got %s", w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestXMLEncoder(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	w := getUsersAs(t, server, mock, "/rest/users", "text/html;q=0.9, application/xml")
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rows>" +
+		"<row><id>1</id><first_name>Al</first_name><last_name>Smith, Jr.</last_name><email_address>guy@somewhere.com</email_address><age>30</age></row>" +
+		"<row><id>2</id><first_name>Jo</first_name><last_name>&lt;Doe&gt;</last_name><email_address/><age>15</age></row></rows>"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %s but got %s", expected, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestXMLEncoderEscapesInvalidNames(t *testing.T) {
+	var buffer strings.Builder
+	err := XMLEncoder{}.Encode(&buffer, nil, map[string]interface{}{"a b": 1, "1x": nil, "ok": map[string]interface{}{"<x>": "y"}})
+	expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<row><field name=\"1x\"/><field name=\"a b\">1</field><ok><field name=\"&lt;x&gt;\">y</field></ok></row>"
+	if err != nil || buffer.String() != expected {
+		t.Errorf("Expected %s but got %s, %v", expected, buffer.String(), err)
+	}
+	for name, expected := range map[string]bool{"id": true, "_x-1.é": true, "1x": false, "a b": false, "ns:id": false, "-x": false, "": false} {
+		if isXMLName(name) != expected {
+			t.Errorf("Expected isXMLName(%q) to be %t", name, expected)
+		}
+	}
+}
+
+type tsvEncoder struct{}
+
+func (tsvEncoder) ContentType() string {
+	return "text/tab-separated-values"
+}
+
+func (tsvEncoder) Encode(w io.Writer, table *Table, result interface{}) error {
+	_, err := io.WriteString(w, table.Name+"\t"+stringValue(len(resultRows(result))))
+	return err
+}
+
+func TestNegotiateEncoder(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.RegisterEncoder("tsv", tsvEncoder{})
+	for accept, expected := range map[string]string{
+		"":                                  "application/json",
+		"*/*":                               "application/json",
+		"text/*":                            "text/csv; charset=utf-8",
+		"text/*, text/tab-separated-values": "text/tab-separated-values",
+		"application/json;q=0.5, text/csv":  "text/csv; charset=utf-8",
+		"application/x-ndjson, */*;q=0.1":   "application/x-ndjson",
+		"image/png, application/xml;q=0":    "",
+		"application/xml, application/json": "application/json",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "application/json",
+		"text/html, application/xml;q=0.9":                                "application/xml; charset=utf-8",
+	} {
+		r := httptest.NewRequest("GET", "/rest/users", nil)
+		r.Header.Set("Accept", accept)
+		encoder, err := server.negotiateEncoder(r)
+		if expected == "" {
+			if err == nil || err.(ApiError).HTTPStatusCode != NOT_ACCEPTABLE {
+				t.Errorf("Expected %q to fail with %d but got %v", accept, NOT_ACCEPTABLE, err)
+			}
+		} else if err != nil || encoder.ContentType() != expected {
+			t.Errorf("Expected %q to select %s but got %v", accept, expected, encoder)
+		}
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/users?format=yaml", nil))
+	if w.Code != NOT_ACCEPTABLE {
+		t.Errorf("Expected an unknown format to fail with %d but got %d", NOT_ACCEPTABLE, w.Code)
+	}
+	cleanUp(server.handler)
+}
+
+func TestDeleteIgnoresAcceptHeader(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	mock.ExpectPrepare("DELETE FROM users WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/rest/users/1", nil)
+	r.Header.Set("Accept", "image/png")
+	server.ServeHTTP(w, r)
+	if w.Code != NO_CONTENT {
+		t.Errorf("Expected status code %d but got %d %s", NO_CONTENT, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
@@ -0,0 +1,75 @@
+package autorest
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func (handler *Handler) etagFor(tableName string, result interface{}) string {
+	item, ok := result.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	table := handler.GetTable(tableName)
+	if table != nil && table.VersionColumn != "" {
+		if version, ok := item[table.VersionColumn]; ok && version != nil {
+			return "\"" + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(version))) + "\""
+		}
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return ""
+	}
+	hash := sha1.Sum(data)
+	return "\"" + hex.EncodeToString(hash[:]) + "\""
+}
+
+func parseETags(header string) []string {
+	etags := make([]string, 0)
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// etagMatches compares etag against an If-Match or If-None-Match header value.
+// Weak comparison, which ignores the W/ prefix, is used for If-None-Match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range parseETags(header) {
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func isWildcardETag(header string) bool {
+	return strings.TrimSpace(header) == "*"
+}
+
+func versionsFromETags(header string) []interface{} {
+	versions := make([]interface{}, 0)
+	for _, etag := range parseETags(header) {
+		if strings.HasPrefix(etag, "W/") || len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+			continue
+		}
+		version, err := base64.RawURLEncoding.DecodeString(etag[1 : len(etag)-1])
+		if err == nil {
+			versions = append(versions, string(version))
+		}
+	}
+	return versions
+}
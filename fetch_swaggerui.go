@@ -0,0 +1,88 @@
+//go:build ignore
+
+// fetch_swaggerui downloads the pinned swagger-ui-dist release from npm and
+// writes the files EnableSwaggerUI serves into the swaggerui directory. Run
+// it with go generate after changing SWAGGER_UI_VERSION.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const SWAGGER_UI_VERSION = "4.15.5"
+
+var files = map[string]bool{
+	"package/swagger-ui.css":       true,
+	"package/swagger-ui-bundle.js": true,
+	"package/LICENSE":              true,
+}
+
+func main() {
+	registry := "https://registry.npmjs.org/swagger-ui-dist/" + SWAGGER_UI_VERSION
+	response, err := http.Get(registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var metadata struct {
+		Dist struct {
+			Tarball   string `json:"tarball"`
+			Integrity string `json:"integrity"`
+		} `json:"dist"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&metadata)
+	response.Body.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	response, err = http.Get(metadata.Dist.Tarball)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer response.Body.Close()
+	tarball, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hash := sha512.Sum512(tarball)
+	if integrity := "sha512-" + base64.StdEncoding.EncodeToString(hash[:]); integrity != metadata.Dist.Integrity {
+		log.Fatalf("Expected %s to have integrity %s but got %s", metadata.Dist.Tarball, metadata.Dist.Integrity, integrity)
+	}
+	archive, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !files[header.Name] {
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join("swaggerui", filepath.Base(header.Name)), data, 0644); err != nil {
+			log.Fatal(err)
+		}
+		delete(files, header.Name)
+	}
+	for name := range files {
+		log.Fatalf("swagger-ui-dist %s has no %s", SWAGGER_UI_VERSION, name)
+	}
+}
@@ -0,0 +1,973 @@
+package autorest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GraphQLOptions configures the GraphQL endpoint. Queries nested deeper than
+// MaxDepth or costing more than MaxComplexity are rejected before they run.
+// Every field costs 1, and the fields below a list cost as much as the list
+// has rows, i.e. its limit argument or DEFAULT_GRAPHQL_LIST_SIZE.
+type GraphQLOptions struct {
+	Path          string
+	MaxDepth      int
+	MaxComplexity int
+}
+
+const (
+	DEFAULT_GRAPHQL_LIST_SIZE = 10
+	MAX_GRAPHQL_REQUEST_SIZE  = 1 << 20
+)
+
+var graphQLNamePattern = regexp.MustCompile("^[_A-Za-z][_0-9A-Za-z]*$")
+
+// EnableGraphQL serves a GraphQL endpoint at options.Path, /graphql by
+// default, and its schema in SDL at options.Path + "/schema". There is one
+// type per table, with a list and a _by_id query, create_, update_ and
+// delete_ mutations and fields for the rows related through foreign keys.
+// Every table access is made as a REST request would be, so policies, row
+// filters, column access, hooks and overrides apply.
+func (s *Server) EnableGraphQL(options GraphQLOptions) {
+	if options.Path == "" {
+		options.Path = "/graphql"
+	}
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = 10
+	}
+	if options.MaxComplexity <= 0 {
+		options.MaxComplexity = 1000
+	}
+	options.Path = "/" + strings.Trim(options.Path, "/")
+	s.mux.Handle(options.Path, s.withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveGraphQL(w, r, options)
+	})))
+	s.mux.Handle(options.Path+"/schema", s.withCORS(http.HandlerFunc(s.serveGraphQLSchema)))
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (s *Server) serveGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		s.respondWithError(ApiError{METHOD_NOT_SUPPORTED}, w)
+		return
+	}
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		s.respondWithError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(s.graphQLSchema(principal).sdl()))
+}
+
+func (s *Server) serveGraphQL(w http.ResponseWriter, r *http.Request, options GraphQLOptions) {
+	w.Header().Set("Content-Type", "application/json")
+	request, err := parseGraphQLRequest(w, r)
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	r = withPrincipal(r, principal)
+	if err = s.rateLimiter.allow(r, "graphql", ALL_ACTIONS, w); err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	schema := s.graphQLSchema(principal)
+	operation, fields, err := planGraphQL(schema, request, options)
+	if err != nil {
+		s.respond(BAD_REQUEST, map[string]interface{}{"errors": []interface{}{map[string]interface{}{"message": err.Error()}}}, w)
+		return
+	}
+	if operation.kind == "mutation" && r.Method != "POST" {
+		s.respondWithError(ApiError{METHOD_NOT_SUPPORTED}, w)
+		return
+	}
+	execution := &gqlExecution{
+		server:     s,
+		principal:  principal,
+		privileged: s.isPrivileged != nil && s.isPrivileged(r),
+		ctx:        r.Context(),
+		request:    r,
+		w:          w,
+	}
+	response := map[string]interface{}{"data": execution.executeFields(fields, nil, nil)}
+	if len(execution.errors) > 0 {
+		response["errors"] = execution.errors
+	}
+	s.respond(OK, response, w)
+}
+
+// parseGraphQLRequest reads a request from a JSON body of at most
+// MAX_GRAPHQL_REQUEST_SIZE bytes for POST, or from the query, operationName
+// and variables query parameters for GET.
+func parseGraphQLRequest(w http.ResponseWriter, r *http.Request) (graphQLRequest, error) {
+	var request graphQLRequest
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return request, ApiError{BAD_REQUEST}
+			}
+		}
+	case "POST":
+		body := http.MaxBytesReader(w, r.Body, MAX_GRAPHQL_REQUEST_SIZE)
+		defer body.Close()
+		if err := json.NewDecoder(body).Decode(&request); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				return request, ApiError{REQUEST_TOO_LARGE}
+			}
+			return request, ApiError{BAD_REQUEST}
+		}
+	default:
+		return request, ApiError{METHOD_NOT_SUPPORTED}
+	}
+	if request.Query == "" {
+		return request, ApiError{BAD_REQUEST}
+	}
+	return request, nil
+}
+
+type gqlSchema struct {
+	objects  map[string]*gqlObject
+	inputs   map[string]*gqlInput
+	query    *gqlObject
+	mutation *gqlObject
+}
+
+type gqlObject struct {
+	name   string
+	fields []*gqlFieldDefinition
+}
+
+type gqlInput struct {
+	name   string
+	fields []gqlArgument
+}
+
+// gqlFieldDefinition is a field of a type. Fields without a resolver return
+// the value of the same name in their parent row. Object is the name of the
+// type of the rows a field returns, and empty for scalar fields.
+type gqlFieldDefinition struct {
+	name      string
+	arguments []gqlArgument
+	typ       string
+	object    string
+	list      bool
+	resolve   gqlResolver
+}
+
+type gqlResolver func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error)
+
+type gqlArgument struct {
+	name string
+	typ  string
+}
+
+func (o *gqlObject) field(name string) *gqlFieldDefinition {
+	for _, field := range o.fields {
+		if field.name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+func (o *gqlObject) add(field *gqlFieldDefinition) bool {
+	if o.field(field.name) != nil {
+		return false
+	}
+	o.fields = append(o.fields, field)
+	return true
+}
+
+// graphQLSchema builds the schema as seen by a principal, leaving out the
+// tables they may not read and the columns that are hidden from them.
+func (s *Server) graphQLSchema(principal *Principal) *gqlSchema {
+	schema := &gqlSchema{
+		objects:  make(map[string]*gqlObject),
+		inputs:   make(map[string]*gqlInput),
+		query:    &gqlObject{name: "Query"},
+		mutation: &gqlObject{name: "Mutation"},
+	}
+	tableNames := make([]string, 0)
+	for _, name := range s.handler.graphQLTableNames() {
+		if s.handler.visibleTo(principal, name, GET_ALL, GET) {
+			tableNames = append(tableNames, name)
+		}
+	}
+	for _, name := range tableNames {
+		table := s.handler.tables[name]
+		schema.objects[name] = graphQLObject(table, principal)
+		schema.inputs[name+"_input"] = graphQLInput(table, principal)
+		schema.query.add(graphQLListField(name, name, table, principal, ""))
+		schema.query.add(&gqlFieldDefinition{
+			name:      name + "_by_id",
+			arguments: []gqlArgument{{"id", "Int!"}},
+			typ:       name,
+			object:    name,
+			resolve:   getResolver(name),
+		})
+		schema.mutation.add(&gqlFieldDefinition{
+			name:      "create_" + name,
+			arguments: []gqlArgument{{"data", name + "_input!"}},
+			typ:       name,
+			object:    name,
+			resolve:   writeResolver(name, POST),
+		})
+		schema.mutation.add(&gqlFieldDefinition{
+			name:      "update_" + name,
+			arguments: []gqlArgument{{"id", "Int!"}, {"data", name + "_input!"}},
+			typ:       name,
+			object:    name,
+			resolve:   writeResolver(name, PUT),
+		})
+		schema.mutation.add(&gqlFieldDefinition{
+			name:      "delete_" + name,
+			arguments: []gqlArgument{{"id", "Int!"}},
+			typ:       "Boolean",
+			resolve:   writeResolver(name, DELETE),
+		})
+	}
+	for _, name := range tableNames {
+		s.handler.addGraphQLRelations(schema, s.handler.tables[name], principal)
+	}
+	return schema
+}
+
+// graphQLTableNames returns the names of the tables that are exposed and are
+// valid GraphQL names, in order.
+func (h *Handler) graphQLTableNames() []string {
+	names := make([]string, 0, len(h.tables))
+	for name := range h.tables {
+		if h.HasTable(name) && graphQLNamePattern.MatchString(name) && name != "Query" && name != "Mutation" && !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func graphQLObject(table *Table, principal *Principal) *gqlObject {
+	object := &gqlObject{name: table.Name}
+	for _, column := range table.Columns {
+		if column.isHiddenFor(principal) || !graphQLNamePattern.MatchString(column.Name) {
+			continue
+		}
+		typ := graphQLType(column.Type)
+		if !column.Nullable && column.Type != "" {
+			typ += "!"
+		}
+		object.add(&gqlFieldDefinition{name: column.Name, typ: typ})
+	}
+	for _, field := range table.ComputedFields {
+		object.add(&gqlFieldDefinition{name: field.Name, typ: graphQLType(field.Type)})
+	}
+	return object
+}
+
+func graphQLInput(table *Table, principal *Principal) *gqlInput {
+	input := &gqlInput{name: table.Name + "_input"}
+	for _, column := range table.Columns {
+		if column.isHiddenFor(principal) || table.isReadOnlyForEveryone(column) || !graphQLNamePattern.MatchString(column.Name) {
+			continue
+		}
+		input.fields = append(input.fields, gqlArgument{column.Name, graphQLType(column.Type)})
+	}
+	return input
+}
+
+// graphQLType returns the GraphQL scalar for a MySQL data type. Columns
+// without a known type are strings.
+func graphQLType(dataType string) string {
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year":
+		return "Int"
+	case "float", "double", "real":
+		return "Float"
+	case "json":
+		return "JSON"
+	default:
+		return "String"
+	}
+}
+
+// graphQLListField returns a field listing the rows of a table, with
+// arguments to filter by its integer and string columns, except the one a
+// relation already filters by, and to sort and paginate.
+func graphQLListField(name, tableName string, table *Table, principal *Principal, relationColumn string) *gqlFieldDefinition {
+	arguments := make([]gqlArgument, 0)
+	for _, column := range table.Columns {
+		if column.Name == relationColumn || !column.isFilterableFor(principal) || !graphQLNamePattern.MatchString(column.Name) {
+			continue
+		}
+		if typ := graphQLType(column.Type); typ == "Int" || typ == "String" {
+			arguments = append(arguments, gqlArgument{column.Name, typ})
+		}
+	}
+	arguments = append(arguments, gqlArgument{"sort", "String"}, gqlArgument{"limit", "Int"}, gqlArgument{"offset", "Int"})
+	return &gqlFieldDefinition{
+		name:      name,
+		arguments: arguments,
+		typ:       "[" + tableName + "!]",
+		object:    tableName,
+		list:      true,
+		resolve: func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+			return e.list(tableName, arguments, nil)
+		},
+	}
+}
+
+// addGraphQLRelations adds a field for the row each foreign key of a table
+// refers to, named after the column without its _id suffix, and a field to
+// every referenced table listing the rows that refer to it, named after the
+// table. Relations to tables that are not exposed are left out.
+func (h *Handler) addGraphQLRelations(schema *gqlSchema, table *Table, principal *Principal) {
+	object := schema.objects[table.Name]
+	referencesPerTable := make(map[string]int)
+	for _, key := range table.ForeignKeys {
+		referencesPerTable[key.ReferencedTable]++
+	}
+	for _, key := range table.ForeignKeys {
+		referenced, ok := schema.objects[key.ReferencedTable]
+		column := h.tables[key.ReferencedTable].GetColumn(key.ReferencedColumn)
+		if !ok || object.field(key.Column) == nil || column == nil || column.isHiddenFor(principal) {
+			continue
+		}
+		name := strings.TrimSuffix(key.Column, "_id")
+		if name == key.Column || name == "" || object.field(name) != nil {
+			name = key.Column + "_" + key.ReferencedTable
+		}
+		object.add(&gqlFieldDefinition{
+			name:    name,
+			typ:     key.ReferencedTable,
+			object:  key.ReferencedTable,
+			resolve: h.referenceResolver(key),
+		})
+		name = table.Name
+		if referencesPerTable[key.ReferencedTable] > 1 || referenced.field(name) != nil {
+			name = table.Name + "_by_" + key.Column
+		}
+		field := graphQLListField(name, table.Name, table, principal, key.Column)
+		field.resolve = referrersResolver(table.Name, key)
+		referenced.add(field)
+	}
+}
+
+func getResolver(tableName string) gqlResolver {
+	return func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		return e.handle(Request{Table: tableName, Action: GET, Id: arguments["id"].(int64), hasId: true})
+	}
+}
+
+func writeResolver(tableName string, action int) gqlResolver {
+	return func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		r := Request{Table: tableName, Action: action, QueryParameters: make(map[string]interface{})}
+		if id, ok := arguments["id"].(int64); ok {
+			r.Id, r.hasId = id, true
+		}
+		if data, ok := arguments["data"].(map[string]interface{}); ok {
+			r.Data = data
+		}
+		result, err := e.handle(r)
+		if action == DELETE && err == nil {
+			return true, nil
+		}
+		return result, err
+	}
+}
+
+func (h *Handler) referenceResolver(key ForeignKey) gqlResolver {
+	return func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		value := parent[key.Column]
+		if value == nil {
+			return nil, nil
+		}
+		if key.ReferencedColumn == h.tables[key.ReferencedTable].PKColumn && isIntegerValue(value) {
+			result, err := e.handle(Request{Table: key.ReferencedTable, Action: GET, Id: toInt64(value), hasId: true})
+			if apiError, ok := err.(ApiError); ok && apiError.HTTPStatusCode == NOT_FOUND {
+				return nil, nil
+			}
+			return result, err
+		}
+		result, err := e.list(key.ReferencedTable, nil, map[string]interface{}{key.ReferencedColumn: value})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range result.([]map[string]interface{}) {
+			return row, nil
+		}
+		return nil, nil
+	}
+}
+
+func referrersResolver(tableName string, key ForeignKey) gqlResolver {
+	return func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		value := parent[key.ReferencedColumn]
+		if value == nil {
+			return []map[string]interface{}{}, nil
+		}
+		return e.list(tableName, arguments, map[string]interface{}{key.Column: value})
+	}
+}
+
+func isIntegerValue(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+type gqlExecution struct {
+	server     *Server
+	principal  *Principal
+	privileged bool
+	ctx        context.Context
+	request    *http.Request
+	w          http.ResponseWriter
+	errors     []interface{}
+}
+
+// handle performs a request the way a REST request for it would be,
+// including charging it against the rate limit of its table and action.
+func (e *gqlExecution) handle(r Request) (interface{}, error) {
+	if err := e.server.rateLimiter.allow(e.request, r.Table, r.Action, e.w); err != nil {
+		return nil, err
+	}
+	r.Principal = e.principal
+	r.privileged = e.privileged
+	r.ctx = e.ctx
+	return e.server.handler.HandleRequest(r)
+}
+
+// list returns the rows of a table that match the arguments and equal the
+// given values, at most DEFAULT_GRAPHQL_LIST_SIZE unless a limit is given, as
+// costed by complexity.
+func (e *gqlExecution) list(tableName string, arguments map[string]interface{}, equal map[string]interface{}) (interface{}, error) {
+	parameters := map[string]interface{}{"limit": strconv.Itoa(DEFAULT_GRAPHQL_LIST_SIZE)}
+	for name, value := range arguments {
+		switch {
+		case value == nil:
+		case name == "limit" || name == "offset":
+			parameters[name] = strconv.FormatInt(value.(int64), 10)
+		default:
+			parameters[name] = value
+		}
+	}
+	r := Request{Table: tableName, Action: GET_ALL, QueryParameters: parameters}
+	for _, name := range sortedKeys(equal) {
+		delete(parameters, name)
+		r.equal = append(r.equal, scopeValue{column: name, value: equal[name]})
+	}
+	return e.handle(r)
+}
+
+// gqlResult is a response object, which keeps its fields in the order they
+// were selected in.
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	buffer := []byte{'{'}
+	for i, key := range r.keys {
+		if i > 0 {
+			buffer = append(buffer, ',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer = append(append(append(buffer, name...), ':'), value...)
+	}
+	return append(buffer, '}'), nil
+}
+
+func (e *gqlExecution) executeFields(fields []*gqlField, parent map[string]interface{}, path []interface{}) *gqlResult {
+	result := &gqlResult{values: make(map[string]interface{})}
+	for _, field := range fields {
+		fieldPath := append(append([]interface{}{}, path...), field.key)
+		var value interface{}
+		var err error
+		if field.definition.resolve != nil {
+			value, err = field.definition.resolve(e, parent, field.arguments)
+		} else {
+			value = parent[field.definition.name]
+		}
+		if err != nil {
+			e.addError(err, fieldPath)
+			value = nil
+		} else if field.children != nil {
+			value = e.completeValue(field, value, fieldPath)
+		}
+		result.keys = append(result.keys, field.key)
+		result.values[field.key] = value
+	}
+	return result
+}
+
+func (e *gqlExecution) completeValue(field *gqlField, value interface{}, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if !field.definition.list {
+		row, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		return e.executeFields(field.children, row, path)
+	}
+	rows, ok := value.([]map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list := make([]interface{}, len(rows))
+	for i, row := range rows {
+		list[i] = e.executeFields(field.children, row, append(append([]interface{}{}, path...), i))
+	}
+	return list
+}
+
+func (e *gqlExecution) addError(err error, path []interface{}) {
+	statusCode, _ := strconv.Atoi(err.Error())
+	e.errors = append(e.errors, map[string]interface{}{
+		"message":    "Server returned status code " + err.Error(),
+		"path":       path,
+		"extensions": map[string]interface{}{"status": statusCode},
+	})
+}
+
+// gqlField is a field to execute, after fragments have been expanded,
+// directives applied and fields with the same response key merged.
+type gqlField struct {
+	key        string
+	definition *gqlFieldDefinition
+	arguments  map[string]interface{}
+	children   []*gqlField
+}
+
+type gqlPlanner struct {
+	schema    *gqlSchema
+	document  *gqlDocument
+	variables map[string]interface{}
+	defined   map[string]bool
+	options   GraphQLOptions
+	spreading map[string]bool
+}
+
+// planGraphQL parses and validates a request, returning the operation to
+// execute and its fields, or an error to respond with before anything runs.
+func planGraphQL(schema *gqlSchema, request graphQLRequest, options GraphQLOptions) (*gqlOperation, []*gqlField, error) {
+	document, err := parseGraphQL(request.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	operation, err := selectOperation(document, request.OperationName)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &gqlPlanner{
+		schema:    schema,
+		document:  document,
+		variables: make(map[string]interface{}),
+		defined:   make(map[string]bool),
+		options:   options,
+		spreading: make(map[string]bool),
+	}
+	for _, variable := range operation.variables {
+		value, ok := request.Variables[variable.name]
+		if !ok && variable.hasDefault {
+			value, ok = variable.defaultValue, true
+		}
+		if (!ok || value == nil) && variable.nonNull {
+			return nil, nil, errors.New("Variable $" + variable.name + " is required")
+		}
+		p.defined[variable.name] = true
+		p.variables[variable.name] = value
+	}
+	var root *gqlObject
+	switch operation.kind {
+	case "query":
+		root = schema.query
+	case "mutation":
+		root = schema.mutation
+	default:
+		return nil, nil, errors.New("Subscriptions are not supported")
+	}
+	fields, err := p.plan(root, operation.selections, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if complexity(fields, options.MaxComplexity) > options.MaxComplexity {
+		return nil, nil, fmt.Errorf("Query is more complex than the maximum of %d", options.MaxComplexity)
+	}
+	return operation, fields, nil
+}
+
+func selectOperation(document *gqlDocument, name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(document.operations) > 1 {
+			return nil, errors.New("operationName is required when the document contains several operations")
+		}
+		return document.operations[0], nil
+	}
+	for _, operation := range document.operations {
+		if operation.name == name {
+			return operation, nil
+		}
+	}
+	return nil, errors.New("Unknown operation " + name)
+}
+
+type gqlCollectedField struct {
+	key        string
+	definition *gqlFieldDefinition
+	arguments  map[string]interface{}
+	selections []*gqlSelection
+}
+
+func (p *gqlPlanner) plan(object *gqlObject, selections []*gqlSelection, depth int) ([]*gqlField, error) {
+	if depth > p.options.MaxDepth {
+		return nil, fmt.Errorf("Query is nested deeper than the maximum of %d", p.options.MaxDepth)
+	}
+	collected := make([]*gqlCollectedField, 0)
+	if err := p.collect(object, selections, &collected); err != nil {
+		return nil, err
+	}
+	fields := make([]*gqlField, 0, len(collected))
+	for _, c := range collected {
+		field := &gqlField{key: c.key, definition: c.definition, arguments: c.arguments}
+		if c.definition.object != "" {
+			if len(c.selections) == 0 {
+				return nil, errors.New("Field " + c.definition.name + " of type " + c.definition.typ + " must have a selection of subfields")
+			}
+			children, err := p.plan(p.schema.objects[c.definition.object], c.selections, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			field.children = children
+		} else if len(c.selections) > 0 {
+			return nil, errors.New("Field " + c.definition.name + " of type " + c.definition.typ + " has no subfields")
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// collect expands fragments and merges the fields with the same response key.
+func (p *gqlPlanner) collect(object *gqlObject, selections []*gqlSelection, collected *[]*gqlCollectedField) error {
+	for _, selection := range selections {
+		included, err := p.included(selection.directives)
+		if err != nil {
+			return err
+		}
+		if !included {
+			continue
+		}
+		switch {
+		case selection.fragment != "":
+			fragment, ok := p.document.fragments[selection.fragment]
+			if !ok {
+				return errors.New("Unknown fragment " + selection.fragment)
+			}
+			if p.spreading[fragment.name] {
+				return errors.New("Fragment " + fragment.name + " spreads itself")
+			}
+			if fragment.typeCondition != object.name {
+				return errors.New("Fragment " + fragment.name + " cannot be spread on type " + object.name)
+			}
+			p.spreading[fragment.name] = true
+			err = p.collect(object, fragment.selections, collected)
+			delete(p.spreading, fragment.name)
+		case selection.inline:
+			if selection.typeCondition != "" && selection.typeCondition != object.name {
+				return errors.New("Inline fragment on " + selection.typeCondition + " cannot be spread on type " + object.name)
+			}
+			err = p.collect(object, selection.selections, collected)
+		default:
+			err = p.collectField(object, selection, collected)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gqlPlanner) collectField(object *gqlObject, selection *gqlSelection, collected *[]*gqlCollectedField) error {
+	definition := object.field(selection.name)
+	if selection.name == "__typename" {
+		definition = &gqlFieldDefinition{name: "__typename", typ: "String!", resolve: typenameResolver(object.name)}
+	}
+	if definition == nil {
+		return errors.New("Cannot query field " + selection.name + " on type " + object.name)
+	}
+	arguments, err := p.coerceArguments(definition, selection.arguments)
+	if err != nil {
+		return err
+	}
+	key := selection.responseKey()
+	for _, c := range *collected {
+		if c.key != key {
+			continue
+		}
+		if c.definition.name != definition.name || fmt.Sprint(c.arguments) != fmt.Sprint(arguments) {
+			return errors.New("Fields " + key + " conflict because they differ in name or arguments")
+		}
+		c.selections = append(c.selections, selection.selections...)
+		return nil
+	}
+	*collected = append(*collected, &gqlCollectedField{key: key, definition: definition, arguments: arguments, selections: selection.selections})
+	return nil
+}
+
+func typenameResolver(name string) gqlResolver {
+	return func(e *gqlExecution, parent map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		return name, nil
+	}
+}
+
+// included applies the @skip and @include directives.
+func (p *gqlPlanner) included(directives []gqlDirective) (bool, error) {
+	for _, directive := range directives {
+		if directive.name != "skip" && directive.name != "include" {
+			return false, errors.New("Unknown directive @" + directive.name)
+		}
+		value, err := p.coerce(directive.arguments["if"], "Boolean!")
+		if err != nil {
+			return false, err
+		}
+		if value.(bool) == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (p *gqlPlanner) coerceArguments(definition *gqlFieldDefinition, values map[string]interface{}) (map[string]interface{}, error) {
+	arguments := make(map[string]interface{})
+	for name := range values {
+		known := false
+		for _, argument := range definition.arguments {
+			known = known || argument.name == name
+		}
+		if !known {
+			return nil, errors.New("Unknown argument " + name + " on field " + definition.name)
+		}
+	}
+	for _, argument := range definition.arguments {
+		value, err := p.coerce(values[argument.name], argument.typ)
+		if err != nil {
+			return nil, errors.New("Argument " + argument.name + " on field " + definition.name + ": " + err.Error())
+		}
+		if number, ok := value.(int64); ok && number < 0 && definition.list && (argument.name == "limit" || argument.name == "offset") {
+			return nil, errors.New("Argument " + argument.name + " on field " + definition.name + " may not be negative")
+		}
+		if value != nil {
+			arguments[argument.name] = value
+		}
+	}
+	return arguments, nil
+}
+
+// coerce replaces the variables in a value and checks it against a type.
+func (p *gqlPlanner) coerce(value interface{}, typ string) (interface{}, error) {
+	if variable, ok := value.(gqlVariable); ok {
+		if !p.defined[variable.name] {
+			return nil, errors.New("Variable $" + variable.name + " is not defined")
+		}
+		value = p.variables[variable.name]
+	}
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		if nonNull {
+			return nil, errors.New("Expected a value of type " + typ + "!")
+		}
+		return nil, nil
+	}
+	switch typ {
+	case "Int":
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "String":
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case "Boolean":
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case "JSON":
+		return p.constant(value)
+	default:
+		input, ok := p.schema.inputs[typ]
+		object, isObject := value.(map[string]interface{})
+		if !ok || !isObject {
+			break
+		}
+		result := make(map[string]interface{})
+		for name, fieldValue := range object {
+			field := -1
+			for i, candidate := range input.fields {
+				if candidate.name == name {
+					field = i
+				}
+			}
+			if field < 0 {
+				return nil, errors.New("Unknown field " + name + " of " + typ)
+			}
+			coerced, err := p.coerce(fieldValue, input.fields[field].typ)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = coerced
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("Expected a value of type %s but got %v", typ, value)
+}
+
+// constant replaces the variables in a JSON value.
+func (p *gqlPlanner) constant(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case gqlVariable:
+		return p.coerce(v, "JSON")
+	case gqlEnum:
+		return string(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			coerced, err := p.constant(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{})
+		for key, item := range v {
+			coerced, err := p.constant(item)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = coerced
+		}
+		return object, nil
+	default:
+		return value, nil
+	}
+}
+
+// complexity adds up the cost of fields, stopping once it exceeds max. Every
+// list counts as at least one row.
+func complexity(fields []*gqlField, max int) int {
+	total := 0
+	for _, field := range fields {
+		total++
+		if field.children == nil {
+			continue
+		}
+		rows := 1
+		if field.definition.list {
+			rows = DEFAULT_GRAPHQL_LIST_SIZE
+			if limit, ok := field.arguments["limit"].(int64); ok {
+				rows = int(limit)
+				if limit > int64(max) {
+					rows = max + 1
+				}
+			}
+			if rows < 1 {
+				rows = 1
+			}
+		}
+		children := complexity(field.children, max)
+		if children > (max-total)/rows {
+			return max + 1
+		}
+		total += rows * children
+	}
+	return total
+}
+
+// sdl describes the schema in the GraphQL schema definition language.
+func (schema *gqlSchema) sdl() string {
+	var sdl strings.Builder
+	sdl.WriteString("scalar JSON\n\nschema {\n  query: Query\n  mutation: Mutation\n}\n")
+	objects := []*gqlObject{schema.query, schema.mutation}
+	names := make([]string, 0, len(schema.objects))
+	for name := range schema.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		objects = append(objects, schema.objects[name])
+	}
+	for _, object := range objects {
+		sdl.WriteString("\ntype " + object.name + " {\n")
+		for _, field := range object.fields {
+			sdl.WriteString("  " + field.name)
+			if len(field.arguments) > 0 {
+				sdl.WriteString("(" + sdlArguments(field.arguments) + ")")
+			}
+			sdl.WriteString(": " + field.typ + "\n")
+		}
+		sdl.WriteString("}\n")
+	}
+	for _, name := range names {
+		input := schema.inputs[name+"_input"]
+		sdl.WriteString("\ninput " + input.name + " {\n")
+		for _, field := range input.fields {
+			sdl.WriteString("  " + field.name + ": " + field.typ + "\n")
+		}
+		sdl.WriteString("}\n")
+	}
+	return sdl.String()
+}
+
+func sdlArguments(arguments []gqlArgument) string {
+	parts := make([]string, len(arguments))
+	for i, argument := range arguments {
+		parts[i] = argument.name + ": " + argument.typ
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,532 @@
+package autorest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This is a parser for the executable subset of GraphQL: operations,
+// fragments, variables and directives. Type system definitions are not
+// supported, since the schema is generated from the database.
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDefinition
+	selections []*gqlSelection
+}
+
+type gqlVariableDefinition struct {
+	name         string
+	nonNull      bool
+	defaultValue interface{}
+	hasDefault   bool
+}
+
+type gqlFragment struct {
+	name          string
+	typeCondition string
+	selections    []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (fragment is set) or an inline
+// fragment (inline is set).
+type gqlSelection struct {
+	alias         string
+	name          string
+	arguments     map[string]interface{}
+	directives    []gqlDirective
+	selections    []*gqlSelection
+	fragment      string
+	inline        bool
+	typeCondition string
+}
+
+type gqlDirective struct {
+	name      string
+	arguments map[string]interface{}
+}
+
+// gqlVariable is a reference to a variable in a value.
+type gqlVariable struct {
+	name string
+}
+
+// gqlEnum is an enum value, which autorest treats like a string.
+type gqlEnum string
+
+func (s *gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+const (
+	gqlEOF = iota
+	gqlPunctuator
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+}
+
+// MAX_GRAPHQL_NESTING limits how deeply selection sets, values and types may
+// be nested, so that a document can't exhaust the stack while it is parsed,
+// before MaxDepth is checked.
+const MAX_GRAPHQL_NESTING = 100
+
+type gqlParser struct {
+	source string
+	offset int
+	token  gqlToken
+	depth  int
+}
+
+func parseGraphQL(source string) (document *gqlDocument, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if parseErr, ok := recovered.(gqlSyntaxError); ok {
+				document, err = nil, parseErr
+				return
+			}
+			panic(recovered)
+		}
+	}()
+	p := &gqlParser{source: source}
+	p.next()
+	document = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.token.kind != gqlEOF {
+		switch {
+		case p.peek(gqlPunctuator, "{"):
+			document.operations = append(document.operations, &gqlOperation{kind: "query", selections: p.parseSelectionSet()})
+		case p.peek(gqlName, "fragment"):
+			fragment := p.parseFragment()
+			if _, ok := document.fragments[fragment.name]; ok {
+				p.fail("There can be only one fragment named " + fragment.name)
+			}
+			document.fragments[fragment.name] = fragment
+		case p.peek(gqlName, "query"), p.peek(gqlName, "mutation"), p.peek(gqlName, "subscription"):
+			document.operations = append(document.operations, p.parseOperation())
+		default:
+			p.fail("Unexpected " + p.describe())
+		}
+	}
+	if len(document.operations) == 0 {
+		return nil, errors.New("The document contains no operations")
+	}
+	return document, nil
+}
+
+type gqlSyntaxError struct {
+	message string
+}
+
+func (e gqlSyntaxError) Error() string {
+	return e.message
+}
+
+func (p *gqlParser) fail(message string) {
+	panic(gqlSyntaxError{"Syntax error at offset " + strconv.Itoa(p.offset) + ": " + message})
+}
+
+// enter descends into a nested selection set, value or type, and returns a
+// function that leaves it again.
+func (p *gqlParser) enter() func() {
+	p.depth++
+	if p.depth > MAX_GRAPHQL_NESTING {
+		p.fail("The document is nested more than " + strconv.Itoa(MAX_GRAPHQL_NESTING) + " levels deep")
+	}
+	return func() { p.depth-- }
+}
+
+func (p *gqlParser) describe() string {
+	if p.token.kind == gqlEOF {
+		return "end of document"
+	}
+	return "\"" + p.token.value + "\""
+}
+
+func (p *gqlParser) peek(kind int, value string) bool {
+	return p.token.kind == kind && p.token.value == value
+}
+
+func (p *gqlParser) skip(kind int, value string) bool {
+	if p.peek(kind, value) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(kind int, value string) {
+	if !p.skip(kind, value) {
+		p.fail("Expected \"" + value + "\" but found " + p.describe())
+	}
+}
+
+func (p *gqlParser) expectName() string {
+	if p.token.kind != gqlName {
+		p.fail("Expected a name but found " + p.describe())
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *gqlParser) parseOperation() *gqlOperation {
+	operation := &gqlOperation{kind: p.expectName()}
+	if p.token.kind == gqlName {
+		operation.name = p.expectName()
+	}
+	if p.skip(gqlPunctuator, "(") {
+		for !p.skip(gqlPunctuator, ")") {
+			p.expect(gqlPunctuator, "$")
+			variable := gqlVariableDefinition{name: p.expectName()}
+			p.expect(gqlPunctuator, ":")
+			variable.nonNull = p.parseType()
+			if p.skip(gqlPunctuator, "=") {
+				variable.defaultValue = p.parseValue(true)
+				variable.hasDefault = true
+			}
+			p.parseDirectives()
+			operation.variables = append(operation.variables, variable)
+		}
+	}
+	p.parseDirectives()
+	operation.selections = p.parseSelectionSet()
+	return operation
+}
+
+// parseType skips a type reference, returning whether it is non-null.
+func (p *gqlParser) parseType() bool {
+	defer p.enter()()
+	if p.skip(gqlPunctuator, "[") {
+		p.parseType()
+		p.expect(gqlPunctuator, "]")
+	} else {
+		p.expectName()
+	}
+	return p.skip(gqlPunctuator, "!")
+}
+
+func (p *gqlParser) parseFragment() *gqlFragment {
+	p.expect(gqlName, "fragment")
+	fragment := &gqlFragment{name: p.expectName()}
+	if fragment.name == "on" {
+		p.fail("Fragments may not be named \"on\"")
+	}
+	p.expect(gqlName, "on")
+	fragment.typeCondition = p.expectName()
+	p.parseDirectives()
+	fragment.selections = p.parseSelectionSet()
+	return fragment
+}
+
+func (p *gqlParser) parseSelectionSet() []*gqlSelection {
+	defer p.enter()()
+	p.expect(gqlPunctuator, "{")
+	selections := make([]*gqlSelection, 0)
+	for !p.skip(gqlPunctuator, "}") {
+		selections = append(selections, p.parseSelection())
+	}
+	if len(selections) == 0 {
+		p.fail("Selection sets may not be empty")
+	}
+	return selections
+}
+
+func (p *gqlParser) parseSelection() *gqlSelection {
+	if p.skip(gqlPunctuator, "...") {
+		if p.token.kind == gqlName && p.token.value != "on" {
+			return &gqlSelection{fragment: p.expectName(), directives: p.parseDirectives()}
+		}
+		selection := &gqlSelection{inline: true}
+		if p.skip(gqlName, "on") {
+			selection.typeCondition = p.expectName()
+		}
+		selection.directives = p.parseDirectives()
+		selection.selections = p.parseSelectionSet()
+		return selection
+	}
+	selection := &gqlSelection{name: p.expectName()}
+	if p.skip(gqlPunctuator, ":") {
+		selection.alias = selection.name
+		selection.name = p.expectName()
+	}
+	selection.arguments = p.parseArguments()
+	selection.directives = p.parseDirectives()
+	if p.peek(gqlPunctuator, "{") {
+		selection.selections = p.parseSelectionSet()
+	}
+	return selection
+}
+
+func (p *gqlParser) parseArguments() map[string]interface{} {
+	arguments := make(map[string]interface{})
+	if !p.skip(gqlPunctuator, "(") {
+		return arguments
+	}
+	for !p.skip(gqlPunctuator, ")") {
+		name := p.expectName()
+		p.expect(gqlPunctuator, ":")
+		arguments[name] = p.parseValue(false)
+	}
+	return arguments
+}
+
+func (p *gqlParser) parseDirectives() []gqlDirective {
+	directives := make([]gqlDirective, 0)
+	for p.skip(gqlPunctuator, "@") {
+		directives = append(directives, gqlDirective{name: p.expectName(), arguments: p.parseArguments()})
+	}
+	return directives
+}
+
+func (p *gqlParser) parseValue(constant bool) interface{} {
+	defer p.enter()()
+	token := p.token
+	switch {
+	case token.kind == gqlPunctuator && token.value == "$" && !constant:
+		p.next()
+		return gqlVariable{p.expectName()}
+	case token.kind == gqlPunctuator && token.value == "[":
+		p.next()
+		list := make([]interface{}, 0)
+		for !p.skip(gqlPunctuator, "]") {
+			list = append(list, p.parseValue(constant))
+		}
+		return list
+	case token.kind == gqlPunctuator && token.value == "{":
+		p.next()
+		object := make(map[string]interface{})
+		for !p.skip(gqlPunctuator, "}") {
+			name := p.expectName()
+			p.expect(gqlPunctuator, ":")
+			object[name] = p.parseValue(constant)
+		}
+		return object
+	case token.kind == gqlInt:
+		p.next()
+		value, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			p.fail("Invalid integer " + token.value)
+		}
+		return value
+	case token.kind == gqlFloat:
+		p.next()
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			p.fail("Invalid float " + token.value)
+		}
+		return value
+	case token.kind == gqlString:
+		p.next()
+		return token.value
+	case token.kind == gqlName:
+		p.next()
+		switch token.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		default:
+			return gqlEnum(token.value)
+		}
+	default:
+		p.fail("Expected a value but found " + p.describe())
+		return nil
+	}
+}
+
+// next reads the next token, skipping whitespace, commas and comments.
+func (p *gqlParser) next() {
+	for p.offset < len(p.source) {
+		c := p.source[p.offset]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.offset++
+		} else if strings.HasPrefix(p.source[p.offset:], "\uFEFF") {
+			p.offset += len("\uFEFF")
+		} else if c == '#' {
+			for p.offset < len(p.source) && p.source[p.offset] != '\n' && p.source[p.offset] != '\r' {
+				p.offset++
+			}
+		} else {
+			break
+		}
+	}
+	if p.offset >= len(p.source) {
+		p.token = gqlToken{kind: gqlEOF}
+		return
+	}
+	start := p.offset
+	c := p.source[p.offset]
+	switch {
+	case strings.HasPrefix(p.source[p.offset:], "..."):
+		p.offset += 3
+		p.token = gqlToken{gqlPunctuator, "..."}
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		p.offset++
+		p.token = gqlToken{gqlPunctuator, string(c)}
+	case c == '_' || isLetter(c):
+		for p.offset < len(p.source) && (p.source[p.offset] == '_' || isLetter(p.source[p.offset]) || isDigit(p.source[p.offset])) {
+			p.offset++
+		}
+		p.token = gqlToken{gqlName, p.source[start:p.offset]}
+	case c == '-' || isDigit(c):
+		p.readNumber()
+	case strings.HasPrefix(p.source[p.offset:], "\"\"\""):
+		p.readBlockString()
+	case c == '"':
+		p.readString()
+	default:
+		p.fail("Unexpected character " + strconv.QuoteRune(rune(c)))
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) readNumber() {
+	start := p.offset
+	kind := gqlInt
+	if p.source[p.offset] == '-' {
+		p.offset++
+	}
+	digits := p.offset
+	for p.offset < len(p.source) && isDigit(p.source[p.offset]) {
+		p.offset++
+	}
+	if p.offset == digits {
+		p.fail("Expected a digit")
+	}
+	if p.offset < len(p.source) && p.source[p.offset] == '.' {
+		kind = gqlFloat
+		p.offset++
+		for p.offset < len(p.source) && isDigit(p.source[p.offset]) {
+			p.offset++
+		}
+	}
+	if p.offset < len(p.source) && (p.source[p.offset] == 'e' || p.source[p.offset] == 'E') {
+		kind = gqlFloat
+		p.offset++
+		if p.offset < len(p.source) && (p.source[p.offset] == '+' || p.source[p.offset] == '-') {
+			p.offset++
+		}
+		for p.offset < len(p.source) && isDigit(p.source[p.offset]) {
+			p.offset++
+		}
+	}
+	p.token = gqlToken{kind, p.source[start:p.offset]}
+}
+
+func (p *gqlParser) readString() {
+	p.offset++
+	var value strings.Builder
+	for {
+		if p.offset >= len(p.source) || p.source[p.offset] == '\n' || p.source[p.offset] == '\r' {
+			p.fail("Unterminated string")
+		}
+		c := p.source[p.offset]
+		switch {
+		case c == '"':
+			p.offset++
+			p.token = gqlToken{gqlString, value.String()}
+			return
+		case c == '\\' && p.offset+1 < len(p.source):
+			p.offset++
+			switch escaped := p.source[p.offset]; escaped {
+			case '"', '\\', '/':
+				value.WriteByte(escaped)
+			case 'b':
+				value.WriteByte('\b')
+			case 'f':
+				value.WriteByte('\f')
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'u':
+				if p.offset+4 >= len(p.source) {
+					p.fail("Invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.source[p.offset+1:p.offset+5], 16, 32)
+				if err != nil {
+					p.fail("Invalid unicode escape")
+				}
+				value.WriteRune(rune(code))
+				p.offset += 4
+			default:
+				p.fail("Invalid escape sequence")
+			}
+			p.offset++
+		default:
+			r, size := utf8.DecodeRuneInString(p.source[p.offset:])
+			value.WriteRune(r)
+			p.offset += size
+		}
+	}
+}
+
+// readBlockString reads a """block string""", removing the common indentation
+// and leading and trailing blank lines.
+func (p *gqlParser) readBlockString() {
+	p.offset += 3
+	end := strings.Index(p.source[p.offset:], "\"\"\"")
+	for end >= 0 && end > 0 && p.source[p.offset+end-1] == '\\' {
+		next := strings.Index(p.source[p.offset+end+3:], "\"\"\"")
+		if next < 0 {
+			end = -1
+			break
+		}
+		end += 3 + next
+	}
+	if end < 0 {
+		p.fail("Unterminated block string")
+	}
+	raw := strings.Replace(p.source[p.offset:p.offset+end], "\\\"\"\"", "\"\"\"", -1)
+	p.offset += end + 3
+	lines := strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimLeft(lines[i], " \t")
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	p.token = gqlToken{gqlString, strings.Join(lines, "\n")}
+}
@@ -0,0 +1,246 @@
+package autorest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func getGraphQLServerForTesting(t *testing.T) (*Server, sqlmock.Sqlmock) {
+	server, mock := getServerForTesting(t)
+	posts := server.handler.GetTable("posts")
+	posts.Columns = append(posts.Columns, &Column{Name: "user_id", Type: "int", Nullable: true})
+	posts.ForeignKeys = []ForeignKey{{Column: "user_id", ReferencedTable: "users", ReferencedColumn: "id"}}
+	server.EnableGraphQL(GraphQLOptions{MaxDepth: 3, MaxComplexity: 50})
+	return server, mock
+}
+
+func postGraphQL(server *Server, query string, variables map[string]interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+	return w
+}
+
+func TestGraphQLQueryFollowsForeignKeys(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	mock.ExpectPrepare("SELECT \\* FROM posts LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body", "user_id"}).AddRow(7, []byte("hello"), 3))
+	mock.ExpectPrepare("SELECT \\* FROM users WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(USERS_COLUMNS).AddRow(3, []byte("first"), []byte("last"), 30, nil))
+	query := `query Posts($limit: Int) {
+		posts(limit: $limit) { id ...author }
+	}
+	fragment author on posts { writer: user { first_name __typename } }`
+	w := postGraphQL(server, query, map[string]interface{}{"limit": 1})
+	expected := `{"data":{"posts":[{"id":7,"writer":{"first_name":"first","__typename":"users"}}]}}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLListsReferringRows(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	mock.ExpectPrepare("SELECT \\* FROM users WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(USERS_COLUMNS).AddRow(3, []byte("first"), []byte("last"), 30, nil))
+	mock.ExpectPrepare("SELECT \\* FROM posts WHERE user_id=\\? LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(3, DEFAULT_GRAPHQL_LIST_SIZE, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, 3).AddRow(8, 3))
+	w := postGraphQL(server, "{ users_by_id(id: 3) { posts { id } } }", nil)
+	expected := `{"data":{"users_by_id":{"posts":[{"id":7},{"id":8}]}}}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLFollowsForeignKeysByExactValue(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	posts := server.handler.GetTable("posts")
+	posts.Columns = append(posts.Columns, &Column{Name: "author_email", Type: "varchar", Nullable: true})
+	posts.ForeignKeys = []ForeignKey{{Column: "author_email", ReferencedTable: "users", ReferencedColumn: "email_address"}}
+	mock.ExpectPrepare("SELECT \\* FROM posts LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_email"}).AddRow(7, []byte("a@b.c")))
+	mock.ExpectPrepare("^SELECT \\* FROM users WHERE email_address=\\? LIMIT \\? OFFSET \\?$").
+		ExpectQuery().
+		WithArgs("a@b.c", DEFAULT_GRAPHQL_LIST_SIZE, 0).
+		WillReturnRows(sqlmock.NewRows(USERS_COLUMNS).AddRow(3, []byte("first"), []byte("last"), 30, []byte("a@b.c")))
+	w := postGraphQL(server, "{ posts(limit: 1) { id author_email_users { id } } }", nil)
+	expected := `{"data":{"posts":[{"id":7,"author_email_users":{"id":3}}]}}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLMutation(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	mock.ExpectPrepare("INSERT INTO products \\(name\\) VALUES \\(\\?\\)").
+		ExpectExec().
+		WithArgs("widget").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("widget"), nil))
+	w := postGraphQL(server, `mutation { create_products(data: {name: "widget"}) { id name } }`, nil)
+	expected := `{"data":{"create_products":{"id":7,"name":"widget"}}}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/graphql?query=mutation+%7B+delete_products(id:+7)+%7D", nil))
+	if w.Code != METHOD_NOT_SUPPORTED {
+		t.Errorf("Expected mutations over GET to fail with %d but got %d", METHOD_NOT_SUPPORTED, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLUsesPolicies(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	server.Allow("products", GET_ALL)
+	mock.ExpectPrepare("SELECT \\* FROM products LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(DEFAULT_GRAPHQL_LIST_SIZE, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("widget"), nil))
+	w := postGraphQL(server, "{ products { name } products_by_id(id: 7) { name } }", nil)
+	expected := `{"data":{"products":[{"name":"widget"}],"products_by_id":null},"errors":[{"extensions":{"status":403},"message":"Server returned status code 403","path":["products_by_id"]}]}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	if w = postGraphQL(server, "{ users { id } }", nil); w.Code != BAD_REQUEST {
+		t.Errorf("Expected tables the caller can't read to be left out of the schema but got %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/graphql/schema", nil))
+	if sdl := w.Body.String(); !strings.Contains(sdl, "type products {") || strings.Contains(sdl, "users") {
+		t.Errorf("Expected the schema to only describe products but got\n%s", sdl)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLUsesTableRateLimits(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	server.SetTableRateLimit("products", RateLimit{Requests: 1, Per: time.Minute}, GET_ALL)
+	mock.ExpectPrepare("SELECT \\* FROM products LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(DEFAULT_GRAPHQL_LIST_SIZE, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("widget"), nil))
+	w := postGraphQL(server, "{ a: products { id } b: products { id } }", nil)
+	expected := `{"data":{"a":[{"id":7}],"b":null},"errors":[{"extensions":{"status":429},"message":"Server returned status code 429","path":["b"]}]}`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLRejectsInvalidQueries(t *testing.T) {
+	server, mock := getGraphQLServerForTesting(t)
+	for _, query := range []string{
+		"{ users { id",
+		"{ users { password } }",
+		"{ users }",
+		"{ users_by_id { id } }",
+		"{ users(limit: \"ten\") { id } }",
+		"{ users { posts { user { posts { id } } } } }",
+		"{ users(limit: 100) { id first_name } }",
+		"query { ...f } fragment f on Query { ...f }",
+		"{ users(offset: -1) { id } }",
+		"{ a: users(limit: -100000) { id } b: users(limit: 1000) { id posts(limit: 1000) { id } } }",
+	} {
+		if w := postGraphQL(server, query, nil); w.Code != BAD_REQUEST {
+			t.Errorf("Expected %s to fail with %d but got %d %s", query, BAD_REQUEST, w.Code, w.Body.String())
+		}
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestGraphQLSchema(t *testing.T) {
+	server, _ := getGraphQLServerForTesting(t)
+	server.ExcludeTables("accounts")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/graphql/schema", nil))
+	sdl := w.Body.String()
+	for _, expected := range []string{
+		"  users_by_id(id: Int!): users\n",
+		"  create_products(data: products_input!): products\n",
+		"  user: users\n",
+		"updated_by: String, sort: String, limit: Int, offset: Int): [posts!]\n",
+		"input orders_input {\n  id: Int\n  total: String\n}\n",
+	} {
+		if !strings.Contains(sdl, expected) {
+			t.Errorf("Expected the schema to contain %q but got\n%s", expected, sdl)
+		}
+	}
+	if strings.Contains(sdl, "accounts") {
+		t.Error("Expected excluded tables to be left out")
+	}
+	cleanUp(server.handler)
+}
+
+func TestParseGraphQL(t *testing.T) {
+	document, err := parseGraphQL(`
+		# a comment
+		query Q($id: Int! = 1, $names: [String]) @cached {
+			a: users_by_id(id: $id) @include(if: true) { ... on users { id } }
+			search(text: """
+				multi
+				  line
+			""", weight: -1.5e2, tags: ["x", BLUE], where: {ok: null})
+		}`)
+	if err != nil {
+		t.Fatalf("An unexpected error occurred: %s", err)
+	}
+	operation := document.operations[0]
+	if operation.kind != "query" || operation.name != "Q" || len(operation.variables) != 2 || !operation.variables[0].nonNull || operation.variables[0].defaultValue != int64(1) {
+		t.Errorf("Unexpected operation %+v", operation)
+	}
+	if field := operation.selections[0]; field.alias != "a" || field.name != "users_by_id" || field.arguments["id"] != (gqlVariable{"id"}) || !field.selections[0].inline {
+		t.Errorf("Unexpected field %+v", field)
+	}
+	arguments := operation.selections[1].arguments
+	if arguments["text"] != "multi\n  line" || arguments["weight"] != -150.0 || arguments["tags"].([]interface{})[1] != gqlEnum("BLUE") {
+		t.Errorf("Unexpected arguments %v", arguments)
+	}
+	if _, err = parseGraphQL(`{ users(name: "unterminated) { id } }`); err == nil {
+		t.Error("Expected an unterminated string to fail")
+	}
+	if _, err = parseGraphQL("{ a(x: " + strings.Repeat("[", 1000000) + ") }"); err == nil {
+		t.Error("Expected deeply nested values to fail")
+	}
+	if _, err = parseGraphQL(strings.Repeat("{ a ", 1000) + strings.Repeat("}", 1000)); err == nil {
+		t.Error("Expected deeply nested selection sets to fail")
+	}
+}
+
+func TestGraphQLLimitsRequestSize(t *testing.T) {
+	server, _ := getGraphQLServerForTesting(t)
+	w := httptest.NewRecorder()
+	body := `{"query": "{ users { id } }", "padding": "` + strings.Repeat("x", MAX_GRAPHQL_REQUEST_SIZE) + `"}`
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(body)))
+	if w.Code != REQUEST_TOO_LARGE {
+		t.Errorf("Expected status code %d but got %d", REQUEST_TOO_LARGE, w.Code)
+	}
+	cleanUp(server.handler)
+}
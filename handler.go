@@ -1,7 +1,9 @@
 package autorest
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -10,7 +12,24 @@ type Handler struct {
 	tables         DatabaseSchema
 	queryBuilder   QueryBuilder
 	excludedTables map[string]bool
+	auditTable     string
+	systemTables   map[string]bool
 	logger         *logger
+
+	requireAuthentication bool
+	policy                *policy
+	readOnly              bool
+	hooks                 hooks
+	overrides             map[string]map[int]OperationFunc
+	customActions         map[string]map[string]OperationFunc
+	queries               map[string]*NamedQuery
+	routines              map[string]*Routine
+}
+
+// Executor is implemented by both *sql.DB and *sql.Tx, so the same Handler
+// methods can be used inside and outside of a transaction.
+type Executor interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 }
 
 func NewHandler(credentials DatabaseCredentials) *Handler {
@@ -19,6 +38,7 @@ func NewHandler(credentials DatabaseCredentials) *Handler {
 	handler.connectToDB(credentials)
 	handler.getDBSchema()
 	handler.excludedTables = make(map[string]bool)
+	handler.systemTables = make(map[string]bool)
 	return handler
 }
 
@@ -42,24 +62,132 @@ func (handler *Handler) connectToDB(credentials DatabaseCredentials) {
 
 func (handler *Handler) getDBSchema() {
 	handler.tables = handler.queryBuilder.ParseSchema(handler.db)
+	handler.routines = handler.queryBuilder.ParseRoutines(handler.db)
 }
 
-func (h *Handler) HandleRequest(r request) (interface{}, error) {
+func (h *Handler) HandleRequest(r Request) (interface{}, error) {
+	if err := h.authorize(r); err != nil {
+		return nil, err
+	}
+	switch r.Action {
+	case QUERY:
+		return h.RunQuery(h.db, r)
+	case RPC:
+		return h.Call(r)
+	}
 	if !h.HasTable(r.Table) {
 		h.logger.Info("Request was made for non-existing table " + r.Table)
 		return nil, ApiError{NOT_FOUND}
 	}
+	if timeout := h.GetTable(r.Table).QueryTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.context(), timeout)
+		defer cancel()
+		r.ctx = ctx
+	}
+	scope, err := h.resolveScope(r)
+	if err != nil {
+		return nil, err
+	}
+	r.scope = scope
+	if err = h.runBeforeHooks(&r); err != nil {
+		return nil, err
+	}
+	result, err := h.perform(r)
+	if err != nil {
+		return nil, err
+	}
+	h.runAfterHooks(&r, result)
+	return result, nil
+}
+
+func (h *Handler) perform(r Request) (interface{}, error) {
+	var result interface{}
+	var err error
+	switch r.Action {
+	case POST, PUT, DELETE, RESTORE:
+		return h.write(r)
+	case CUSTOM:
+		if h.operationFor(r) == nil {
+			return nil, ApiError{NOT_FOUND}
+		}
+		return h.write(r)
+	default:
+		result, err = h.performRead(h.db, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = h.runAfterInTransactionHooks(h.db, &r, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (h *Handler) write(r Request) (interface{}, error) {
+	if h.auditTable == "" && !h.hasAfterInTransactionHooks(&r) && r.Action != CUSTOM {
+		return h.performWrite(h.db, r)
+	}
+	tx, err := h.db.BeginTx(r.context(), nil)
+	if err != nil {
+		return nil, h.databaseError(err)
+	}
+	result, err := h.performTransactionalWrite(tx, r)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, h.databaseError(err)
+	}
+	return result, nil
+}
+
+func (h *Handler) performTransactionalWrite(tx Executor, r Request) (interface{}, error) {
+	var result interface{}
+	var err error
+	if h.auditTable != "" {
+		result, err = h.performAuditedWrite(tx, r)
+	} else {
+		result, err = h.performWrite(tx, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = h.runAfterInTransactionHooks(tx, &r, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (h *Handler) performRead(db Executor, r Request) (interface{}, error) {
+	if operation := h.operationFor(r); operation != nil {
+		return operation(h, r, h.GetTable(r.Table), db)
+	}
 	switch r.Action {
 	case GET:
-		return h.Get(r)
+		return h.Get(db, r)
 	case GET_ALL:
-		return h.GetAll(r)
+		return h.GetAll(db, r)
+	case HISTORY:
+		return h.History(db, r)
+	default:
+		return nil, ApiError{METHOD_NOT_SUPPORTED}
+	}
+}
+
+func (h *Handler) performWrite(db Executor, r Request) (interface{}, error) {
+	if operation := h.operationFor(r); operation != nil {
+		return operation(h, r, h.GetTable(r.Table), db)
+	}
+	switch r.Action {
 	case POST:
-		return h.Post(r)
+		return h.Post(db, r)
 	case PUT:
-		return h.Put(r)
+		return h.Put(db, r)
 	case DELETE:
-		return "", h.Delete(r)
+		return nil, h.Delete(db, r)
+	case RESTORE:
+		return h.Restore(db, r)
 	default:
 		return nil, ApiError{METHOD_NOT_SUPPORTED}
 	}
@@ -68,7 +196,7 @@ func (h *Handler) HandleRequest(r request) (interface{}, error) {
 func (handler *Handler) HasTable(tableName string) bool {
 	_, ok := handler.tables[tableName]
 	_, isExcluded := handler.excludedTables[tableName]
-	return ok && !isExcluded
+	return ok && !isExcluded && !handler.systemTables[tableName]
 }
 
 func (handler *Handler) GetTable(tableName string) *Table {
@@ -79,71 +207,258 @@ func (handler *Handler) GetTable(tableName string) *Table {
 	}
 }
 
-func (handler *Handler) Get(r request) (interface{}, error) {
+func (handler *Handler) mustGetTable(tableName string) *Table {
+	table := handler.GetTable(tableName)
+	if table == nil {
+		panic("Unknown table " + tableName)
+	}
+	return table
+}
+
+func (handler *Handler) Get(db Executor, r Request) (interface{}, error) {
 	table := handler.GetTable(r.Table)
-	stmt, err := handler.db.Prepare(handler.queryBuilder.BuildSelectQuery(table))
+	query, values := handler.queryBuilder.BuildSelectQuery(r, table)
+	rows, err := handler.query(r.context(), db, query, values)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return nil, err
 	}
-	rows, err := stmt.Query(r.Id)
-	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+	if len(rows) == 0 {
+		return nil, ApiError{NOT_FOUND}
 	}
-	columns, err := rows.Columns()
+	handler.maskColumns(r, table, rows)
+	handler.computeFields(table, rows)
+	return rows[0], nil
+}
+
+func (handler *Handler) GetAll(db Executor, r Request) (interface{}, error) {
+	table := handler.GetTable(r.Table)
+	if _, _, err := parsePagination(r); err != nil {
+		return nil, err
+	}
+	query, values := handler.queryBuilder.BuildSelectAllQuery(r, table)
+	if r.stream != nil {
+		return nil, handler.streamAll(db, r, table, query, values)
+	}
+	rows, err := handler.query(r.context(), db, query, values)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return nil, err
 	}
-	defer stmt.Close()
-	defer rows.Close()
-	result := make(map[string]interface{})
-	if rows.Next() {
-		row := make([]interface{}, len(columns))
-		rowPointers := make([]interface{}, len(columns))
-		for i := 0; i < len(columns); i++ {
-			rowPointers[i] = &row[i]
-		}
-		if err = rows.Scan(rowPointers...); err != nil {
-			handler.logger.Error(err.Error())
-			return nil, ApiError{INTERNAL_SERVER_ERROR}
-		}
-		for i, column := range columns {
-			value, err := DetermineTypeForRawValue(rowPointers[i])
-			if err != nil {
-				handler.logger.Error(err.Error())
-				return nil, ApiError{INTERNAL_SERVER_ERROR}
+	handler.maskColumns(r, table, rows)
+	handler.computeFields(table, rows)
+	return rows, nil
+}
+
+// streamAll passes the rows of a GET_ALL to the request's stream as they are
+// read, masked and with their computed fields, instead of returning them.
+func (handler *Handler) streamAll(db Executor, r Request, table *Table, query string, values []interface{}) error {
+	begin := func(columns []string) error {
+		for _, field := range table.ComputedFields {
+			if field.Func != nil {
+				columns = append(columns, field.Name)
 			}
-			result[column] = value
 		}
-	} else {
-		return nil, ApiError{NOT_FOUND}
+		return r.stream.begin(columns)
 	}
-	return result, nil
+	return handler.queryEach(r.context(), db, query, values, begin, func(item map[string]interface{}) error {
+		rows := []map[string]interface{}{item}
+		handler.maskColumns(r, table, rows)
+		handler.computeFields(table, rows)
+		return r.stream.row(item)
+	})
 }
 
-func (handler *Handler) GetAll(r request) (interface{}, error) {
+func (handler *Handler) Post(db Executor, r Request) (interface{}, error) {
 	table := handler.GetTable(r.Table)
-	queryString, parameters := handler.queryBuilder.BuildSelectAllQuery(r, table)
-	stmt, err := handler.db.Prepare(queryString)
+	query, values := handler.queryBuilder.BuildPOSTQueryAndValues(r, table)
+	result, err := handler.exec(r.context(), db, query, values)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return nil, err
+	}
+	return handler.getInsertedItem(db, r, result)
+}
+
+func (handler *Handler) getInsertedItem(db Executor, r Request, result sql.Result) (interface{}, error) {
+	if newId, err := result.LastInsertId(); err == nil {
+		r.Id = newId
+		return handler.Get(db, r)
+	}
+	pkColumn := handler.GetTable(r.Table).PKColumn
+	for key, value := range r.Data {
+		if key == pkColumn {
+			r.Id = value.(int64)
+			return handler.Get(db, r)
+		}
 	}
-	rows, err := stmt.Query(parameters...)
+	return r.Data, nil
+}
+
+func (handler *Handler) Put(db Executor, r Request) (interface{}, error) {
+	table := handler.GetTable(r.Table)
+	if err := handler.checkPrecondition(db, r, table); err != nil {
+		return nil, err
+	}
+	query, values, err := handler.queryBuilder.BuildPUTQueryAndValues(r, table)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		handler.logger.Info("PUT on " + r.Table + " has no columns to update")
+		return nil, err
 	}
-	columns, err := rows.Columns()
+	result, err := handler.exec(r.context(), db, query, values)
+	if err != nil {
+		return nil, err
+	}
+	if err = handler.checkRowsAffected(db, r, result); err != nil {
+		return nil, err
+	}
+	return handler.Get(db, r)
+}
+
+func (handler *Handler) Delete(db Executor, r Request) error {
+	table := handler.GetTable(r.Table)
+	if err := handler.checkPrecondition(db, r, table); err != nil {
+		return err
+	}
+	query, values := handler.queryBuilder.BuildDeleteQuery(r, table)
+	result, err := handler.exec(r.context(), db, query, values)
+	if err != nil {
+		return err
+	}
+	return handler.checkRowsAffected(db, r, result)
+}
+
+func (handler *Handler) Restore(db Executor, r Request) (interface{}, error) {
+	table := handler.GetTable(r.Table)
+	if table.SoftDeleteColumn == "" {
+		return nil, ApiError{NOT_FOUND}
+	}
+	if !r.privileged {
+		return nil, ApiError{FORBIDDEN}
+	}
+	r.IncludeDeleted = true
+	if err := handler.checkPrecondition(db, r, table); err != nil {
+		return nil, err
+	}
+	query, values := handler.queryBuilder.BuildRestoreQuery(r, table)
+	result, err := handler.exec(r.context(), db, query, values)
+	if err != nil {
+		return nil, err
+	}
+	if err = handler.checkRowsAffected(db, r, result); err != nil {
+		return nil, err
+	}
+	return handler.Get(db, r)
+}
+
+// checkPrecondition enforces If-Match for tables without a version column by
+// comparing against the current row. Tables with a version column have the
+// check built into the WHERE clause of the write instead.
+func (handler *Handler) checkPrecondition(db Executor, r Request, table *Table) error {
+	if r.IfMatch == "" || isWildcardETag(r.IfMatch) {
+		return nil
+	}
+	if table.VersionColumn != "" {
+		if len(versionsFromETags(r.IfMatch)) == 0 {
+			return ApiError{PRECONDITION_FAILED}
+		}
+		return nil
+	}
+	current, err := handler.Get(db, r)
+	if err != nil {
+		return err
+	}
+	if !etagMatches(r.IfMatch, handler.etagFor(r.Table, current), false) {
+		return ApiError{PRECONDITION_FAILED}
+	}
+	return nil
+}
+
+func (handler *Handler) checkRowsAffected(db Executor, r Request, result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return ApiError{INTERNAL_SERVER_ERROR}
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+	if isWildcardETag(r.IfMatch) {
+		return ApiError{PRECONDITION_FAILED}
+	}
+	if r.IfMatch != "" && handler.GetTable(r.Table).VersionColumn != "" {
+		if _, err := handler.Get(db, r); err == nil {
+			return ApiError{PRECONDITION_FAILED}
+		}
+	}
+	return ApiError{NOT_FOUND}
+}
+
+func (handler *Handler) query(ctx context.Context, db Executor, query string, values []interface{}) ([]map[string]interface{}, error) {
+	result := make([]map[string]interface{}, 0)
+	err := handler.queryEach(ctx, db, query, values, nil, func(item map[string]interface{}) error {
+		result = append(result, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// errStopRows can be returned by the callback of queryEach to stop reading
+// rows without failing.
+var errStopRows = errors.New("stop reading rows")
+
+// queryEach runs a query and passes its rows to each one at a time, without
+// holding on to them. If begin is given, it receives the column names once
+// the query has succeeded, before the first row. Errors returned by begin
+// and each are returned as they are.
+func (handler *Handler) queryEach(ctx context.Context, db Executor, query string, values []interface{}, begin func(columns []string) error, each func(item map[string]interface{}) error) error {
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return handler.databaseError(err)
 	}
 	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, values...)
+	if err != nil {
+		return handler.databaseError(err)
+	}
 	defer rows.Close()
+	err = handler.eachRow(rows, begin, each)
+	if err == errStopRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = rows.Err(); err != nil {
+		return handler.databaseError(err)
+	}
+	return nil
+}
+
+// scanRows reads the rows of the current result set.
+func (handler *Handler) scanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
 	result := make([]map[string]interface{}, 0)
+	err := handler.eachRow(rows, nil, func(item map[string]interface{}) error {
+		result = append(result, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (handler *Handler) eachRow(rows *sql.Rows, begin func(columns []string) error, each func(item map[string]interface{}) error) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		handler.logger.Error(err.Error())
+		return ApiError{INTERNAL_SERVER_ERROR}
+	}
+	if begin != nil {
+		if err = begin(columns); err != nil {
+			return err
+		}
+	}
 	for rows.Next() {
 		item := make(map[string]interface{})
 		row := make([]interface{}, len(columns))
@@ -152,83 +467,49 @@ func (handler *Handler) GetAll(r request) (interface{}, error) {
 			rowPointers[i] = &row[i]
 		}
 		if err = rows.Scan(rowPointers...); err != nil {
-			handler.logger.Error(err.Error())
-			return nil, ApiError{INTERNAL_SERVER_ERROR}
+			return handler.databaseError(err)
 		}
 		for i, column := range columns {
 			value, err := DetermineTypeForRawValue(rowPointers[i])
 			if err != nil {
 				handler.logger.Error(err.Error())
-				return nil, ApiError{INTERNAL_SERVER_ERROR}
+				return ApiError{INTERNAL_SERVER_ERROR}
 			}
 			item[column] = value
 		}
-		result = append(result, item)
-	}
-	return result, nil
-}
-
-func (handler *Handler) Post(r request) (interface{}, error) {
-	table := handler.GetTable(r.Table)
-	query, values := handler.queryBuilder.BuildPOSTQueryAndValues(r, table)
-	stmt, err := handler.db.Prepare(query)
-	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
-	}
-	result, err := stmt.Exec(values...)
-	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
-	}
-	defer stmt.Close()
-	return handler.getInsertedItem(r, result)
-}
-
-func (handler *Handler) getInsertedItem(r request, result sql.Result) (interface{}, error) {
-	if newId, err := result.LastInsertId(); err == nil {
-		r.Id = newId
-		return handler.Get(r)
-	}
-	pkColumn := handler.GetTable(r.Table).PKColumn
-	for key, value := range r.Data {
-		if key == pkColumn {
-			r.Id = value.(int64)
-			return handler.Get(r)
+		if err = each(item); err != nil {
+			return err
 		}
 	}
-	return r.Data, nil
+	return nil
 }
 
-func (handler *Handler) Put(r request) (interface{}, error) {
-	table := handler.GetTable(r.Table)
-	query, values := handler.queryBuilder.BuildPUTQueryAndValues(r, table)
-	stmt, err := handler.db.Prepare(query)
+func (handler *Handler) exec(ctx context.Context, db Executor, query string, values []interface{}) (sql.Result, error) {
+	stmt, err := db.PrepareContext(ctx, query)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return nil, handler.databaseError(err)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(values...)
+	result, err := stmt.ExecContext(ctx, values...)
 	if err != nil {
-		handler.logger.Error(err.Error())
-		return nil, ApiError{INTERNAL_SERVER_ERROR}
+		return nil, handler.databaseError(err)
 	}
-	return handler.Get(r)
+	return result, nil
 }
 
-func (handler *Handler) Delete(r request) error {
-	table := handler.GetTable(r.Table)
-	stmt, err := handler.db.Prepare(handler.queryBuilder.BuildDeleteQuery(table))
-	if err != nil {
+// databaseError logs a database error and converts it to an ApiError. Queries
+// that ran past their table's timeout become 504s; queries cancelled because
+// the client went away are only logged as info.
+func (handler *Handler) databaseError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
 		handler.logger.Error(err.Error())
+		return ApiError{GATEWAY_TIMEOUT}
+	case errors.Is(err, context.Canceled):
+		handler.logger.Info(err.Error())
 		return ApiError{INTERNAL_SERVER_ERROR}
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(r.Id)
-	if err != nil {
+	default:
 		handler.logger.Error(err.Error())
 		return ApiError{INTERNAL_SERVER_ERROR}
 	}
-	return nil
 }
@@ -1,8 +1,11 @@
 package autorest
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/DATA-DOG/go-sqlmock"
 	"testing"
+	"time"
 )
 
 var USERS_COLUMNS = []string{"id", "first_name", "last_name", "age", "email_address"}
@@ -15,6 +18,7 @@ func getHandlerForTesting(t *testing.T) (*Handler, sqlmock.Sqlmock) {
 	h.getQueryBuilder(cred)
 	h.tables = getTestingSchema()
 	h.logger = newLogger(NONE, nil, 0)
+	h.systemTables = make(map[string]bool)
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error ocurred with sqlmock %s", err)
@@ -45,6 +49,44 @@ func getTestingSchema() (schema DatabaseSchema) {
 			&Column{Name: "cost"},
 		},
 	}
+	schema["orders"] = &Table{
+		Name:     "orders",
+		PKColumn: "id",
+		Columns: []*Column{
+			&Column{Name: "id", Type: "int"},
+			&Column{Name: "total", Type: "decimal"},
+			&Column{Name: "version", Type: "int"},
+		},
+		VersionColumn: "version",
+	}
+	schema["accounts"] = &Table{
+		Name:     "accounts",
+		PKColumn: "id",
+		Columns: []*Column{
+			&Column{Name: "id", Type: "int"},
+			&Column{Name: "name", Type: "varchar"},
+			&Column{Name: "deleted_at", Type: "datetime"},
+		},
+		SoftDeleteColumn: "deleted_at",
+	}
+	schema["posts"] = &Table{
+		Name:     "posts",
+		PKColumn: "id",
+		Columns: []*Column{
+			&Column{Name: "id", Type: "int"},
+			&Column{Name: "body", Type: "text"},
+			&Column{Name: "created_at", Type: "datetime"},
+			&Column{Name: "updated_at", Type: "datetime"},
+			&Column{Name: "created_by", Type: "varchar"},
+			&Column{Name: "updated_by", Type: "varchar"},
+		},
+		AuditColumns: AuditColumns{
+			CreatedAt: "created_at",
+			UpdatedAt: "updated_at",
+			CreatedBy: "created_by",
+			UpdatedBy: "updated_by",
+		},
+	}
 	return
 }
 
@@ -70,7 +112,7 @@ func cleanUp(handler *Handler) {
 
 func TestGet(t *testing.T) {
 	handler, mock := getHandlerForTesting(t)
-	r := request{Table: "users", Action: GET, Id: 1}
+	r := Request{Table: "users", Action: GET, Id: 1}
 	mock.ExpectPrepare("SELECT \\* FROM users WHERE id=\\?").
 		ExpectQuery().
 		WithArgs(1).
@@ -91,7 +133,7 @@ func TestGet(t *testing.T) {
 
 func TestGetAll(t *testing.T) {
 	handler, mock := getHandlerForTesting(t)
-	r := request{Table: "users", Action: GET_ALL}
+	r := Request{Table: "users", Action: GET_ALL}
 	mock.ExpectPrepare("SELECT \\* FROM users").
 		ExpectQuery().
 		WillReturnRows(sqlmock.NewRows(USERS_COLUMNS).
@@ -122,7 +164,7 @@ func TestPost(t *testing.T) {
 	data["first_name"] = "first"
 	data["last_name"] = "last"
 	data["age"] = 30
-	r := request{Table: "users", Action: POST, Data: data}
+	r := Request{Table: "users", Action: POST, Data: data}
 	mock.ExpectPrepare("INSERT INTO users (.+) VALUES (.+)").
 		ExpectExec().
 		WithArgs(30, "first", "last").
@@ -151,7 +193,7 @@ func TestPut(t *testing.T) {
 	data["first_name"] = "first"
 	data["last_name"] = "last"
 	data["age"] = 30
-	r := request{Table: "users", Action: PUT, Data: data, Id: 1}
+	r := Request{Table: "users", Action: PUT, Data: data, Id: 1}
 	mock.ExpectPrepare("UPDATE users SET (.+) WHERE id=\\?").
 		ExpectExec().
 		WithArgs(30, "first", "last", 1).
@@ -176,7 +218,7 @@ func TestPut(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	handler, mock := getHandlerForTesting(t)
-	r := request{Table: "users", Action: DELETE, Id: 1}
+	r := Request{Table: "users", Action: DELETE, Id: 1}
 	mock.ExpectPrepare("DELETE FROM users WHERE id=\\?").
 		ExpectExec().
 		WithArgs(1).
@@ -193,7 +235,7 @@ func TestExcludeTable(t *testing.T) {
 	handler, mock := getHandlerForTesting(t)
 	handler.excludedTables = make(map[string]bool)
 	handler.excludedTables["users"] = true
-	r := request{Table: "users", Action: GET, Id: 1}
+	r := Request{Table: "users", Action: GET, Id: 1}
 	_, err := handler.HandleRequest(r)
 	if err.(ApiError).HTTPStatusCode != 404 {
 		t.Errorf("An unexpected error occurred: %s", err)
@@ -201,3 +243,341 @@ func TestExcludeTable(t *testing.T) {
 	checkExpectationsWereMet(t, mock)
 	cleanUp(handler)
 }
+
+func TestPutMissingRow(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["age"] = 30
+	r := Request{Table: "users", Action: PUT, Data: data, Id: 1}
+	mock.ExpectPrepare("UPDATE users SET age=\\? WHERE id=\\?").
+		ExpectExec().
+		WithArgs(30, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != NOT_FOUND {
+		t.Errorf("Expected a 404 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPutWithoutColumns(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["version"] = 99
+	r := Request{Table: "orders", Action: PUT, Data: data, Id: 1}
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != BAD_REQUEST {
+		t.Errorf("Expected a 400 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestDeleteMissingRow(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "users", Action: DELETE, Id: 1}
+	mock.ExpectPrepare("DELETE FROM users WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != NOT_FOUND {
+		t.Errorf("Expected a 404 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestDeleteMissingRowWithWildcard(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "users", Action: DELETE, Id: 1, IfMatch: "*"}
+	mock.ExpectPrepare("DELETE FROM users WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != PRECONDITION_FAILED {
+		t.Errorf("Expected a 412 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPutWithMatchingVersion(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["total"] = 10
+	data["version"] = 99
+	r := Request{Table: "orders", Action: PUT, Data: data, Id: 1, IfMatch: "\"Mw\""}
+	mock.ExpectPrepare("UPDATE orders SET total=\\?,version=version\\+1 WHERE id=\\? AND version IN \\(\\?\\)").
+		ExpectExec().
+		WithArgs(10, 1, "3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT \\* FROM orders WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "total", "version"}).AddRow(1, []byte("10"), 4))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPutWithStaleVersion(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["total"] = 10
+	r := Request{Table: "orders", Action: PUT, Data: data, Id: 1, IfMatch: "\"Mw\""}
+	mock.ExpectPrepare("UPDATE orders SET total=\\?,version=version\\+1 WHERE id=\\? AND version IN \\(\\?\\)").
+		ExpectExec().
+		WithArgs(10, 1, "3").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("SELECT \\* FROM orders WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "total", "version"}).AddRow(1, []byte("5"), 4))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != PRECONDITION_FAILED {
+		t.Errorf("Expected a 412 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestDeleteWithMismatchedHash(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "products", Action: DELETE, Id: 1, IfMatch: "\"not-the-current-hash\""}
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(1, []byte("widget"), nil))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != PRECONDITION_FAILED {
+		t.Errorf("Expected a 412 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestSoftDelete(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "accounts", Action: DELETE, Id: 1}
+	mock.ExpectPrepare("UPDATE accounts SET deleted_at=NOW\\(\\) WHERE id=\\? AND deleted_at IS NULL").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestGetAllExcludesSoftDeletedRows(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "accounts", Action: GET_ALL}
+	mock.ExpectPrepare("SELECT \\* FROM accounts WHERE deleted_at IS NULL").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "deleted_at"}).AddRow(1, []byte("first"), nil))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestIncludeDeletedRequiresPrivilege(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "accounts", Action: GET_ALL, IncludeDeleted: true}
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != FORBIDDEN {
+		t.Errorf("Expected a 403 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestRestore(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "accounts", Action: RESTORE, Id: 1, privileged: true}
+	mock.ExpectPrepare("UPDATE accounts SET deleted_at=NULL WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT \\* FROM accounts WHERE id=\\?$").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "deleted_at"}).AddRow(1, []byte("first"), nil))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPostFillsAuditColumns(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["body"] = "hello"
+	data["created_by"] = "someone else"
+	r := Request{Table: "posts", Action: POST, Data: data, Principal: &Principal{Id: "alice"}}
+	mock.ExpectPrepare("INSERT INTO posts \\(body,created_at,updated_at,created_by,updated_by\\) VALUES \\(\\?,NOW\\(\\),NOW\\(\\),\\?,\\?\\)").
+		ExpectExec().
+		WithArgs("hello", "alice", "alice").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare("SELECT \\* FROM posts WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body"}).AddRow(1, []byte("hello")))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPutFillsAuditColumns(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	data := make(map[string]interface{})
+	data["body"] = "hello"
+	data["created_at"] = "2000-01-01 00:00:00"
+	r := Request{Table: "posts", Action: PUT, Data: data, Id: 1, Principal: &Principal{Id: "bob"}}
+	mock.ExpectPrepare("UPDATE posts SET body=\\?,updated_at=NOW\\(\\),updated_by=\\? WHERE id=\\?").
+		ExpectExec().
+		WithArgs("hello", "bob", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT \\* FROM posts WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "body"}).AddRow(1, []byte("hello")))
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPutIsAudited(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	handler.auditTable = "audit_log"
+	data := make(map[string]interface{})
+	data["name"] = "gadget"
+	r := Request{Table: "products", Action: PUT, Data: data, Id: 1, Principal: &Principal{Id: "alice"}}
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, []byte("widget")))
+	mock.ExpectPrepare("UPDATE products SET name=\\? WHERE id=\\?").
+		ExpectExec().
+		WithArgs("gadget", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, []byte("gadget")))
+	mock.ExpectPrepare("INSERT INTO audit_log \\(table_name,record_id,action,principal,before_data,after_data,created_at\\)").
+		ExpectExec().
+		WithArgs("products", 1, "PUT", "alice", "{\"id\":1,\"name\":\"widget\"}", "{\"id\":1,\"name\":\"gadget\"}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	_, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestFailedWriteIsRolledBack(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	handler.auditTable = "audit_log"
+	r := Request{Table: "products", Action: DELETE, Id: 1}
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectPrepare("DELETE FROM products WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != NOT_FOUND {
+		t.Errorf("Expected a 404 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestHistory(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	handler.auditTable = "audit_log"
+	r := Request{Table: "products", Action: HISTORY, Id: 1}
+	mock.ExpectPrepare("SELECT (.+) FROM audit_log WHERE table_name=\\? AND record_id=\\? ORDER BY id").
+		ExpectQuery().
+		WithArgs("products", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "principal", "before_data", "after_data", "created_at"}).
+		AddRow([]byte("POST"), []byte("alice"), nil, []byte("{\"id\":1}"), []byte("2020-01-01 00:00:00")))
+	rawResult, err := handler.HandleRequest(r)
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	result := rawResult.([]map[string]interface{})
+	if string(result[0]["after_data"].(json.RawMessage)) != "{\"id\":1}" {
+		t.Errorf("Expected after_data to be raw JSON but got %v", result[0]["after_data"])
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	handler.GetTable("products").QueryTimeout = time.Nanosecond
+	r := Request{Table: "products", Action: GET_ALL}
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != GATEWAY_TIMEOUT {
+		t.Errorf("Expected status code %d but got %v", GATEWAY_TIMEOUT, err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestCancelledRequestStopsQuery(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := Request{Table: "products", Action: GET_ALL, ctx: ctx}
+	if _, err := handler.HandleRequest(r); err == nil {
+		t.Error("Expected an error for a cancelled request")
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestGetAllWithPagination(t *testing.T) {
+	handler, mock := getHandlerForTesting(t)
+	r := Request{Table: "products", Action: GET_ALL, QueryParameters: map[string]interface{}{"limit": "10", "offset": "20", "sort": "name"}}
+	mock.ExpectPrepare("SELECT \\* FROM products ORDER BY name ASC LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	if _, err := handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	r.QueryParameters["limit"] = "ten"
+	if _, err := handler.HandleRequest(r); err == nil || err.(ApiError).HTTPStatusCode != BAD_REQUEST {
+		t.Errorf("Expected a 400 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
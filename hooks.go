@@ -0,0 +1,119 @@
+package autorest
+
+// BeforeHook runs before autorest handles a request, after it has been
+// authorized. It may change the request, e.g. its Data, or abort it by
+// returning an error. ApiErrors are sent to the client as they are, other
+// errors are logged and sent as 500s.
+type BeforeHook func(r *Request) error
+
+// AfterHook runs after a request has been handled successfully, and after its
+// transaction, if any, has been committed. result is nil for DELETE.
+type AfterHook func(r *Request, result interface{})
+
+// AfterInTransactionHook runs after a write, inside the same transaction. An
+// error rolls the write back. For reads, which do not use a transaction, db is
+// the database itself.
+type AfterInTransactionHook func(r *Request, result interface{}, db Executor) error
+
+type hookTarget struct {
+	table  string
+	action int
+}
+
+func (t hookTarget) matches(r *Request) bool {
+	return (t.table == ALL_TABLES || t.table == r.Table) && (t.action == ALL_ACTIONS || t.action == r.Action)
+}
+
+type hooks struct {
+	before             []beforeHook
+	after              []afterHook
+	afterInTransaction []afterInTransactionHook
+}
+
+type beforeHook struct {
+	hookTarget
+	hook BeforeHook
+}
+
+type afterHook struct {
+	hookTarget
+	hook AfterHook
+}
+
+type afterInTransactionHook struct {
+	hookTarget
+	hook AfterInTransactionHook
+}
+
+// OnBefore registers a hook for an action on a table. Use ALL_TABLES and
+// ALL_ACTIONS to run it for every table or action. Hooks run in the order
+// they were registered.
+func (s *Server) OnBefore(table string, action int, hook BeforeHook) {
+	s.checkHookTable(table)
+	s.handler.hooks.before = append(s.handler.hooks.before, beforeHook{hookTarget{table, action}, hook})
+}
+
+func (s *Server) OnAfter(table string, action int, hook AfterHook) {
+	s.checkHookTable(table)
+	s.handler.hooks.after = append(s.handler.hooks.after, afterHook{hookTarget{table, action}, hook})
+}
+
+func (s *Server) OnAfterInTransaction(table string, action int, hook AfterInTransactionHook) {
+	s.checkHookTable(table)
+	s.handler.hooks.afterInTransaction = append(s.handler.hooks.afterInTransaction, afterInTransactionHook{hookTarget{table, action}, hook})
+}
+
+func (s *Server) checkHookTable(table string) {
+	if table != ALL_TABLES {
+		s.handler.mustGetTable(table)
+	}
+}
+
+func (h *Handler) runBeforeHooks(r *Request) error {
+	for _, before := range h.hooks.before {
+		if !before.matches(r) {
+			continue
+		}
+		if err := before.hook(r); err != nil {
+			return h.hookError(err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) hasAfterInTransactionHooks(r *Request) bool {
+	for _, after := range h.hooks.afterInTransaction {
+		if after.matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) runAfterInTransactionHooks(db Executor, r *Request, result interface{}) error {
+	for _, after := range h.hooks.afterInTransaction {
+		if !after.matches(r) {
+			continue
+		}
+		if err := after.hook(r, result, db); err != nil {
+			return h.hookError(err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) runAfterHooks(r *Request, result interface{}) {
+	for _, after := range h.hooks.after {
+		if after.matches(r) {
+			after.hook(r, result)
+		}
+	}
+}
+
+func (h *Handler) hookError(err error) error {
+	if apiError, ok := err.(ApiError); ok {
+		return apiError
+	}
+	h.logger.Error(err.Error())
+	return ApiError{INTERNAL_SERVER_ERROR}
+}
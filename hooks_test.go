@@ -0,0 +1,75 @@
+package autorest
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"strings"
+	"testing"
+)
+
+func TestBeforeHookChangesData(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.OnBefore("products", POST, func(r *Request) error {
+		r.Data["name"] = strings.ToLower(r.Data["name"].(string))
+		return nil
+	})
+	var created interface{} = "not called"
+	server.OnAfter(ALL_TABLES, ALL_ACTIONS, func(r *Request, result interface{}) {
+		created = result
+	})
+	mock.ExpectPrepare("INSERT INTO products \\(name\\) VALUES \\(\\?\\)").
+		ExpectExec().
+		WithArgs("widget").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(7, []byte("widget")))
+	r := Request{Table: "products", Action: POST, Data: map[string]interface{}{"name": "WIDGET"}}
+	if _, err := server.handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	if item, ok := created.(map[string]interface{}); !ok || item["name"] != "widget" {
+		t.Errorf("Expected the after hook to receive the new item but got %v", created)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestBeforeHookAbortsRequest(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.OnBefore("products", DELETE, func(r *Request) error {
+		return ApiError{BAD_REQUEST}
+	})
+	server.OnAfter("products", DELETE, func(r *Request, result interface{}) {
+		t.Error("After hooks should not run for aborted requests")
+	})
+	_, err := server.handler.HandleRequest(Request{Table: "products", Action: DELETE, Id: 1})
+	if err == nil || err.(ApiError).HTTPStatusCode != BAD_REQUEST {
+		t.Errorf("Expected a 400 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestAfterInTransactionHookRollsBack(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.OnAfterInTransaction("products", DELETE, func(r *Request, result interface{}, db Executor) error {
+		if db == Executor(server.handler.db) {
+			t.Error("Expected the hook to run in the transaction")
+		}
+		return errors.New("notification failed")
+	})
+	mock.ExpectBegin()
+	mock.ExpectPrepare("DELETE FROM products WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	_, err := server.handler.HandleRequest(Request{Table: "products", Action: DELETE, Id: 1})
+	if err == nil || err.(ApiError).HTTPStatusCode != INTERNAL_SERVER_ERROR {
+		t.Errorf("Expected a 500 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
@@ -0,0 +1,50 @@
+package autorest
+
+import (
+	"net/http"
+	"strings"
+)
+
+const JSON_SCHEMA_DIALECT = "https://json-schema.org/draft/2020-12/schema"
+
+// serveJSONSchema serves the JSON Schema of a table at /rest/_schema/:table,
+// and of every table at /rest/_schema. Tables the caller may not read are
+// left out.
+func (s *Server) serveJSONSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		s.respondWithError(ApiError{METHOD_NOT_SUPPORTED}, w)
+		return
+	}
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, s.prefix+"_schema"), "/")
+	if name == "" {
+		schemas := make(map[string]interface{})
+		for tableName, table := range s.handler.tables {
+			if s.handler.HasTable(tableName) && s.handler.visibleTo(principal, tableName, GET_ALL, GET) {
+				schemas[tableName] = s.tableJSONSchema(table)
+			}
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		s.respond(OK, schemas, w)
+		return
+	}
+	if !s.handler.HasTable(name) || !s.handler.visibleTo(principal, name, GET_ALL, GET) {
+		s.respondWithError(ApiError{NOT_FOUND}, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	s.respond(OK, s.tableJSONSchema(s.handler.GetTable(name)), w)
+}
+
+func (s *Server) tableJSONSchema(table *Table) map[string]interface{} {
+	schema := tableSchema(table)
+	schema["$schema"] = JSON_SCHEMA_DIALECT
+	schema["$id"] = s.prefix + "_schema/" + table.Name
+	schema["title"] = table.Name
+	return schema
+}
@@ -0,0 +1,58 @@
+package autorest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONSchemaForTable(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.SetColumnAccess("users", "email_address", ColumnAccess{Hidden: true})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_schema/users", nil))
+	if w.Code != OK {
+		t.Fatalf("Expected status code %d but got %d", OK, w.Code)
+	}
+	var schema struct {
+		Schema     string                            `json:"$schema"`
+		Title      string                            `json:"title"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatalf("Could not parse the schema: %s", err)
+	}
+	if schema.Schema != JSON_SCHEMA_DIALECT || schema.Title != "users" {
+		t.Errorf("Unexpected $schema %s or title %s", schema.Schema, schema.Title)
+	}
+	if _, ok := schema.Properties["email_address"]; ok {
+		t.Error("Expected hidden columns to be left out")
+	}
+	if schema.Properties["id"]["x-primaryKey"] != true {
+		t.Errorf("Expected id to be marked as the primary key but got %v", schema.Properties["id"])
+	}
+	cleanUp(server.handler)
+}
+
+func TestJSONSchemaForAllTables(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.ExcludeTables("products")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_schema", nil))
+	var schemas map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &schemas); err != nil {
+		t.Fatalf("Could not parse the schemas: %s", err)
+	}
+	if _, ok := schemas["users"]; !ok {
+		t.Error("Expected a schema for users")
+	}
+	if _, ok := schemas["products"]; ok {
+		t.Error("Expected excluded tables to be left out")
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_schema/products", nil))
+	if w.Code != NOT_FOUND {
+		t.Errorf("Expected status code %d but got %d", NOT_FOUND, w.Code)
+	}
+	cleanUp(server.handler)
+}
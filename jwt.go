@@ -0,0 +1,157 @@
+package autorest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTAuthenticator accepts bearer tokens signed with either an HMAC secret
+// (HS256, HS384, HS512) or an RSA key (RS256, RS384, RS512). The token's sub
+// claim becomes the principal's id, the roles claim its roles, and all claims
+// its attributes.
+type JWTAuthenticator struct {
+	Issuer     string
+	Audience   string
+	RolesClaim string
+	Leeway     time.Duration
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+}
+
+func NewHMACJWTAuthenticator(secret []byte) *JWTAuthenticator {
+	return &JWTAuthenticator{RolesClaim: "roles", hmacSecret: secret}
+}
+
+func NewRSAJWTAuthenticator(key *rsa.PublicKey) *JWTAuthenticator {
+	return &JWTAuthenticator{RolesClaim: "roles", rsaKey: key}
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, nil
+	}
+	claims, err := a.parse(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return nil, err
+	}
+	if err = a.validate(claims); err != nil {
+		return nil, err
+	}
+	principal := &Principal{Attributes: claims}
+	principal.Id, _ = claims["sub"].(string)
+	principal.Roles = stringsFromClaim(claims[a.RolesClaim])
+	return principal, nil
+}
+
+func (a *JWTAuthenticator) Challenge() string {
+	return "Bearer realm=\"autorest\""
+}
+
+func (a *JWTAuthenticator) parse(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, errors.New("unsupported token algorithm " + header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	switch {
+	case a.hmacSecret != nil && strings.HasPrefix(header.Alg, "HS"):
+		mac := hmac.New(hash.New, a.hmacSecret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case a.rsaKey != nil && strings.HasPrefix(header.Alg, "RS"):
+		if err = rsa.VerifyPKCS1v15(a.rsaKey, hash, hasher.Sum(nil), signature); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, errors.New("token algorithm " + header.Alg + " does not match the configured key")
+	}
+	claims := make(map[string]interface{})
+	if err = decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) validate(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return errors.New("token has the wrong issuer")
+	}
+	if a.Audience != "" {
+		found := false
+		for _, audience := range stringsFromClaim(claims["aud"]) {
+			found = found || audience == a.Audience
+		}
+		if !found {
+			return errors.New("token has the wrong audience")
+		}
+	}
+	return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringsFromClaim accepts either a JSON array of strings or a space
+// separated string, which are both common ways of encoding roles and scopes.
+func stringsFromClaim(claim interface{}) []string {
+	switch claim.(type) {
+	case string:
+		return strings.Fields(claim.(string))
+	case []interface{}:
+		values := make([]string, 0)
+		for _, value := range claim.([]interface{}) {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package autorest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type requestContextKey struct{}
+
+type parsedRequest struct {
+	r       *http.Request
+	prefix  string
+	once    sync.Once
+	request *Request
+	err     error
+}
+
+// Use adds middleware that wraps every route of the server: the tables,
+// static files and handlers added with RegisterHandler. The first middleware
+// added is the outermost, so it sees the request first and the response last.
+// It must be called before the server starts handling requests.
+func (s *Server) Use(middleware ...func(http.Handler) http.Handler) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+func (s *Server) buildChain() {
+	s.chain = s.mux
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		s.chain = s.middleware[i](s.chain)
+	}
+}
+
+// RequestFromContext returns the parsed request for requests to a table, or
+// nil for other routes. The request, including its body, is only parsed the
+// first time this is called, so middleware that doesn't call it can still
+// wrap or replace the body. Otherwise the request is parsed after all
+// middleware has run. Changes middleware makes to the returned Request are
+// seen by autorest.
+func RequestFromContext(ctx context.Context) *Request {
+	if parsed, ok := ctx.Value(requestContextKey{}).(*parsedRequest); ok {
+		if parsed.parse(parsed.r); parsed.err == nil {
+			return parsed.request
+		}
+	}
+	return nil
+}
+
+// withParsedRequest marks requests routed to the tables, so that middleware
+// can get the parsed request. Other routes are left untouched, since parsing
+// consumes the body.
+func (s *Server) withParsedRequest(r *http.Request) *http.Request {
+	if _, pattern := s.mux.Handler(r); pattern != s.prefix {
+		return r
+	}
+	parsed := &parsedRequest{r: r, prefix: s.prefix}
+	return r.WithContext(context.WithValue(r.Context(), requestContextKey{}, parsed))
+}
+
+// parse parses the request the first time it is called, from r.
+func (p *parsedRequest) parse(r *http.Request) {
+	p.once.Do(func() {
+		request, err := parseRequest(r, p.prefix)
+		p.request, p.err = &request, err
+	})
+}
+
+// requestFor returns the request middleware already parsed, or otherwise
+// parses it now, from the request as the middleware passed it on.
+func (s *Server) requestFor(r *http.Request) (Request, error) {
+	parsed, ok := r.Context().Value(requestContextKey{}).(*parsedRequest)
+	if !ok {
+		return parseRequest(r, s.prefix)
+	}
+	parsed.parse(r)
+	if parsed.err != nil {
+		return Request{}, parsed.err
+	}
+	request := *parsed.request
+	request.ctx = r.Context()
+	return request, nil
+}
@@ -0,0 +1,86 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	order := ""
+	for _, name := range []string{"first", "second"} {
+		name := name
+		server.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order += name + " "
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	server.RegisterHandler("/hello", func(w http.ResponseWriter, r *http.Request) {
+		order += "handler"
+		if RequestFromContext(r.Context()) != nil {
+			t.Error("Expected no parsed request for a registered handler")
+		}
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+	if order != "first second handler" {
+		t.Errorf("Expected middleware to run in the order it was added but got %s", order)
+	}
+	cleanUp(server.handler)
+}
+
+func TestMiddlewareCanChangeRequest(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := RequestFromContext(r.Context())
+			if request == nil || request.Table != "users" || request.Action != GET_ALL {
+				t.Errorf("Expected the parsed request but got %v", request)
+				return
+			}
+			request.QueryParameters["age"] = int64(30)
+			next.ServeHTTP(w, r)
+		})
+	})
+	mock.ExpectPrepare("SELECT \\* FROM users WHERE age = \\?").
+		ExpectQuery().
+		WithArgs(30).
+		WillReturnRows(sqlmock.NewRows(USERS_COLUMNS))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/users", nil))
+	if w.Code != OK {
+		t.Errorf("Expected status code %d but got %d", OK, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestMiddlewareCanReplaceBody(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(`{"name":"gadget"}`))
+			next.ServeHTTP(w, r)
+		})
+	})
+	mock.ExpectPrepare("INSERT INTO products \\(name\\) VALUES \\(\\?\\)").
+		ExpectExec().
+		WithArgs("gadget").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectPrepare("SELECT \\* FROM products WHERE id=\\?").
+		ExpectQuery().
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "cost"}).AddRow(7, []byte("gadget"), nil))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/rest/products", strings.NewReader(`{"name":"widget"}`)))
+	if w.Code != CREATED {
+		t.Errorf("Expected status code %d but got %d %s", CREATED, w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
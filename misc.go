@@ -2,16 +2,32 @@ package autorest
 
 import (
 	"errors"
+	"regexp"
+	"sort"
 	"strconv"
 )
 
 const (
+	OK                    = 200
+	CREATED               = 201
+	NO_CONTENT            = 204
+	NOT_MODIFIED          = 304
 	BAD_REQUEST           = 400
+	UNAUTHORIZED          = 401
+	FORBIDDEN             = 403
 	NOT_FOUND             = 404
 	METHOD_NOT_SUPPORTED  = 405
+	NOT_ACCEPTABLE        = 406
+	PRECONDITION_FAILED   = 412
+	REQUEST_TOO_LARGE     = 413
+	TOO_MANY_REQUESTS     = 429
 	INTERNAL_SERVER_ERROR = 500
+	GATEWAY_TIMEOUT       = 504
 )
 
+// identifierPattern matches names that can safely be used in SQL and paths.
+var identifierPattern = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
 type ApiError struct {
 	HTTPStatusCode int
 }
@@ -48,3 +64,12 @@ func DetermineTypeForRawValue(value interface{}) (interface{}, error) {
 		return nil, nil
 	}
 }
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
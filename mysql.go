@@ -3,6 +3,7 @@ package autorest
 import (
 	"database/sql"
 	"fmt"
+	"math"
 	"strings"
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -10,7 +11,7 @@ import (
 type MysqlQueryBuilder struct{}
 
 func (MysqlQueryBuilder) CreateDSN(credentials DatabaseCredentials) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s",
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?clientFoundRows=true",
 		credentials.Username,
 		credentials.Password,
 		credentials.Host,
@@ -37,11 +38,28 @@ func (MysqlQueryBuilder) ParseSchema(db *sql.DB) DatabaseSchema {
 		cols, pkColumn := MysqlQueryBuilder{}.parseColumns(db, tableName)
 		schema[tableName] = &Table{Name: tableName, Columns: cols, PKColumn: pkColumn}
 	}
+	MysqlQueryBuilder{}.parseForeignKeys(db, schema)
 	return schema
 }
 
+func (MysqlQueryBuilder) parseForeignKeys(db *sql.DB, schema DatabaseSchema) {
+	rows, err := db.Query("SELECT table_name, column_name, referenced_table_name, referenced_column_name FROM information_schema.key_column_usage WHERE table_schema=DATABASE() AND referenced_table_name IS NOT NULL ORDER BY table_name, ordinal_position")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName string
+		var foreignKey ForeignKey
+		rows.Scan(&tableName, &foreignKey.Column, &foreignKey.ReferencedTable, &foreignKey.ReferencedColumn)
+		if table, ok := schema[tableName]; ok {
+			table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+		}
+	}
+}
+
 func (MysqlQueryBuilder) parseColumns(db *sql.DB, tableName string) (cols []*Column, pkCol string) {
-	stmt, err := db.Prepare("SELECT column_name, data_type, column_key FROM information_schema.columns WHERE table_name='" + tableName + "'")
+	stmt, err := db.Prepare("SELECT column_name, data_type, column_key, is_nullable, character_maximum_length, column_type, extra, column_default IS NOT NULL FROM information_schema.columns WHERE table_name='" + tableName + "' ORDER BY ordinal_position")
 	if err != nil {
 		panic(err)
 	}
@@ -56,8 +74,21 @@ func (MysqlQueryBuilder) parseColumns(db *sql.DB, tableName string) (cols []*Col
 		var colName string
 		var colType string
 		var colKey string
-		rows.Scan(&colName, &colType, &colKey)
-		col := Column{Name: colName}
+		var isNullable string
+		var maxLength sql.NullInt64
+		var columnType string
+		var extra string
+		var hasDefault bool
+		rows.Scan(&colName, &colType, &colKey, &isNullable, &maxLength, &columnType, &extra, &hasDefault)
+		col := Column{
+			Name: colName,
+			Type: colType,
+			Nullable: isNullable == "YES",
+			MaxLength: maxLength.Int64,
+			Enum: parseEnumValues(columnType),
+			AutoIncrement: strings.Contains(extra, "auto_increment"),
+			HasDefault: hasDefault || strings.Contains(extra, "DEFAULT_GENERATED"),
+		}
 		cols = append(cols, &col)
 		if colKey == "PRI" {
 			pkCol = colName
@@ -66,116 +97,400 @@ func (MysqlQueryBuilder) parseColumns(db *sql.DB, tableName string) (cols []*Col
 	return
 }
 
-func (MysqlQueryBuilder) BuildSelectQuery(table *Table) string {
-	return "SELECT * FROM " + table.Name + " WHERE " + table.PKColumn + "=?"
+// parseEnumValues returns the values of an enum column type, e.g.
+// enum('small','large'), or nil for other types.
+func parseEnumValues(columnType string) []string {
+	if !strings.HasPrefix(columnType, "enum(") || !strings.HasSuffix(columnType, ")") {
+		return nil
+	}
+	values := columnType[5 : len(columnType)-1]
+	enum := make([]string, 0)
+	var value strings.Builder
+	inQuote := false
+	for i := 0; i < len(values); i++ {
+		c := values[i]
+		switch {
+		case !inQuote:
+			if c == '\'' {
+				inQuote = true
+				value.Reset()
+			}
+		case c == '\'' && i+1 < len(values) && values[i+1] == '\'':
+			value.WriteByte(c)
+			i++
+		case c == '\'':
+			inQuote = false
+			enum = append(enum, value.String())
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return enum
+}
+
+func (MysqlQueryBuilder) ParseRoutines(db *sql.DB) map[string]*Routine {
+	routines := make(map[string]*Routine)
+	rows, err := db.Query("SELECT routine_name, routine_type FROM information_schema.routines WHERE routine_schema=DATABASE()")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		routine := &Routine{Parameters: make([]*RoutineParameter, 0)}
+		rows.Scan(&routine.Name, &routine.Type)
+		routines[routine.Name] = routine
+	}
+	parameters, err := db.Query("SELECT specific_name, parameter_name, parameter_mode, data_type FROM information_schema.parameters WHERE specific_schema=DATABASE() AND ordinal_position > 0 ORDER BY specific_name, ordinal_position")
+	if err != nil {
+		panic(err)
+	}
+	defer parameters.Close()
+	for parameters.Next() {
+		var routineName string
+		var mode sql.NullString
+		parameter := &RoutineParameter{}
+		parameters.Scan(&routineName, &parameter.Name, &mode, &parameter.Type)
+		parameter.Mode = "IN"
+		if mode.Valid {
+			parameter.Mode = mode.String
+		}
+		if routine, ok := routines[routineName]; ok {
+			routine.Parameters = append(routine.Parameters, parameter)
+		}
+	}
+	return routines
+}
+
+func (MysqlQueryBuilder) BuildSelectQuery(r Request, table *Table) (string, []interface{}) {
+	query := "SELECT " + buildSelectList(r, table) + " FROM " + table.Name + " WHERE " + table.PKColumn + "=?"
+	if !r.IncludeDeleted {
+		query += buildNotDeletedCondition(table)
+	}
+	scopeCondition, scopeValues := buildScopeCondition(r)
+	query += scopeCondition
+	return query, append([]interface{}{r.Id}, scopeValues...)
 }
 
-func (MysqlQueryBuilder) BuildSelectAllQuery(r request, table *Table) (query string, values []interface{}) {
-	query = "SELECT * FROM " + table.Name
+func (MysqlQueryBuilder) BuildSelectAllQuery(r Request, table *Table) (query string, values []interface{}) {
+	query = "SELECT " + buildSelectList(r, table) + " FROM " + table.Name
+	conditions := make([]string, 0)
 	values = make([]interface{}, 0)
-	i := 0
-	for column, value := range r.QueryParameters {
-		if table.HasColumn(column) && column != "sort" {
-			if i > 0 {
-				query += " AND "
-			} else {
-				query += " WHERE "
-			}
+	for _, column := range sortedKeys(r.QueryParameters) {
+		value := r.QueryParameters[column]
+		if expression := table.filterExpression(column, r.Principal); expression != "" && !isReservedParameter(column) {
 			switch value.(type) {
 			case int8, int16, int32, int64, uint8, uint16, uint32, uint64:
 				values = append(values, value)
-				query += column + " = ? "
+				conditions = append(conditions, expression + " = ?")
 			case string:
 				values = append(values, "%" + value.(string) + "%")
-				query += column + " LIKE ? "
+				conditions = append(conditions, expression + " LIKE ?")
 			case []byte:
 				values = append(values, "%" + string(value.([]byte)) + "%")
-				query += column + " LIKE ? "
+				conditions = append(conditions, expression + " LIKE ?")
 			}
-			i++
 		}
 	}
+	where := strings.Join(conditions, " AND ")
+	if !r.IncludeDeleted {
+		where += buildNotDeletedCondition(table)
+	}
+	scopeCondition, scopeValues := buildScopeCondition(r)
+	where += scopeCondition
+	values = append(values, scopeValues...)
+	for _, equal := range r.equal {
+		where += " AND " + equal.column + "=?"
+		values = append(values, equal.value)
+	}
+	if where != "" {
+		query += " WHERE " + strings.TrimPrefix(where, " AND ")
+	}
 	query += buildSortClause(r, table)
+	if limit, offset, err := parsePagination(r); err == nil && (limit >= 0 || offset > 0) {
+		if limit < 0 {
+			limit = math.MaxInt64
+		}
+		query += " LIMIT ? OFFSET ?"
+		values = append(values, limit, offset)
+	}
 	return
 }
 
-func buildSortClause(r request, table *Table) string {
+func isReservedParameter(name string) bool {
+	switch name {
+	case "sort", "limit", "offset", "format":
+		return true
+	default:
+		return false
+	}
+}
+
+// buildSelectList selects every column the caller may see, using * unless
+// some columns are hidden from them, and the SQL-backed computed fields.
+func buildSelectList(r Request, table *Table) string {
+	columns := make([]string, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		if r.unrestricted || !column.isHiddenFor(r.Principal) {
+			columns = append(columns, column.Name)
+		}
+	}
+	if len(columns) == len(table.Columns) {
+		columns = []string{"*"}
+	}
+	for _, field := range table.ComputedFields {
+		if field.SQL != "" {
+			columns = append(columns, "(" + field.SQL + ") AS " + field.Name)
+		}
+	}
+	return strings.Join(columns, ",")
+}
+
+func buildSortClause(r Request, table *Table) string {
 	columnString, ok := r.QueryParameters["sort"]
 	if !ok {
 		return ""
 	}
-	var columns []string
-	if strings.Contains(columnString.(string), ",") {
-		columns = strings.Split(columnString.(string), ",")
-	} else {
-		columns = make([]string, 1)
-		columns[0] = columnString.(string)
-	}
-	sortClause := ""
-	if len(columns) > 0 {
-		sortClause += " ORDER BY "
-	}
-	for i, column := range columns {
-		if i > 0 {
-			sortClause += ", "
-		}
-		colName := column
-		sortDescending := column[0] == '-'
-		if sortDescending {
-			colName = column[1:]
-		}
-		if table.HasColumn(colName) {
-			sortClause += colName
-			if sortDescending {
-				sortClause += " DESC"
-			} else {
-				sortClause += " ASC"
-			}
+	sortColumns := make([]string, 0)
+	for _, column := range strings.Split(columnString.(string), ",") {
+		colName := strings.TrimPrefix(column, "-")
+		if table.filterExpression(colName, r.Principal) == "" {
+			continue
+		}
+		if strings.HasPrefix(column, "-") {
+			sortColumns = append(sortColumns, colName + " DESC")
+		} else {
+			sortColumns = append(sortColumns, colName + " ASC")
 		}
 	}
-	return sortClause
+	if len(sortColumns) == 0 {
+		return ""
+	}
+	return " ORDER BY " + strings.Join(sortColumns, ", ")
 }
 
-func (MysqlQueryBuilder) BuildPOSTQueryAndValues(r request, t *Table) (query string, values []interface{}) {
-	query = "INSERT INTO " + t.Name + " ("
+func (MysqlQueryBuilder) BuildPOSTQueryAndValues(r Request, t *Table) (query string, values []interface{}) {
+	columns := make([]string, 0)
+	placeholders := make([]string, 0)
 	values = make([]interface{}, 0)
-	valuesClause := ""
-	i := 0
-	for key, value := range r.Data {
-		if t.HasColumn(key) {
-			if i > 0 {
-				query += ","
-				valuesClause += ","
-			}
-			query += key
-			valuesClause += "?"
-			values = append(values, value)
-			i++
+	for _, key := range sortedKeys(r.Data) {
+		if t.isWritableColumn(key, r.Principal, POST) && !r.isScopeColumn(key) {
+			columns = append(columns, key)
+			placeholders = append(placeholders, "?")
+			values = append(values, r.Data[key])
+		}
+	}
+	for _, scope := range r.scope {
+		columns = append(columns, scope.column)
+		placeholders = append(placeholders, "?")
+		values = append(values, scope.value)
+	}
+	for _, column := range []string{t.AuditColumns.CreatedAt, t.AuditColumns.UpdatedAt} {
+		if column != "" {
+			columns = append(columns, column)
+			placeholders = append(placeholders, "NOW()")
+		}
+	}
+	for _, column := range []string{t.AuditColumns.CreatedBy, t.AuditColumns.UpdatedBy} {
+		if column != "" {
+			columns = append(columns, column)
+			placeholders = append(placeholders, "?")
+			values = append(values, principalId(r.Principal))
 		}
 	}
-	query += ") VALUES (" + valuesClause + ")"
+	query = "INSERT INTO " + t.Name + " (" + strings.Join(columns, ",") + ") VALUES (" + strings.Join(placeholders, ",") + ")"
 	return
 }
 
-func (MysqlQueryBuilder) BuildPUTQueryAndValues(r request, t *Table) (string, []interface{}) {
-	query := "UPDATE " + t.Name + " SET "
+func (MysqlQueryBuilder) BuildPUTQueryAndValues(r Request, t *Table) (string, []interface{}, error) {
+	assignments := make([]string, 0)
 	values := make([]interface{}, 0)
-	i := 0
-	for key, value := range r.Data {
-		if t.HasColumn(key) {
-			if i > 0 {
-				query += ","
-			}
-			query += key + "=?"
-			values = append(values, value)
-			i++
+	for _, key := range sortedKeys(r.Data) {
+		if t.isWritableColumn(key, r.Principal, PUT) && !r.isScopeColumn(key) {
+			assignments = append(assignments, key+"=?")
+			values = append(values, r.Data[key])
 		}
 	}
-	query += " WHERE " + t.PKColumn + "=?"
+	if len(assignments) == 0 {
+		return "", nil, ApiError{BAD_REQUEST}
+	}
+	if increment := buildVersionIncrement(t); increment != "" {
+		assignments = append(assignments, increment)
+	}
+	if t.AuditColumns.UpdatedAt != "" {
+		assignments = append(assignments, t.AuditColumns.UpdatedAt+"=NOW()")
+	}
+	if t.AuditColumns.UpdatedBy != "" {
+		assignments = append(assignments, t.AuditColumns.UpdatedBy+"=?")
+		values = append(values, principalId(r.Principal))
+	}
+	query := "UPDATE " + t.Name + " SET " + strings.Join(assignments, ",")
+	query += " WHERE " + t.PKColumn + "=?" + buildNotDeletedCondition(t)
 	values = append(values, r.Id)
+	scopeCondition, scopeValues := buildScopeCondition(r)
+	query += scopeCondition
+	values = append(values, scopeValues...)
+	versionCondition, versions := buildVersionCondition(r, t)
+	query += versionCondition
+	values = append(values, versions...)
+	return query, values, nil
+}
+
+func (MysqlQueryBuilder) BuildDeleteQuery(r Request, table *Table) (string, []interface{}) {
+	var query string
+	if column := table.GetColumn(table.SoftDeleteColumn); column != nil {
+		query = "UPDATE " + table.Name + " SET " + column.Name + "="
+		if column.IsTemporal() {
+			query += "NOW()"
+		} else {
+			query += "1"
+		}
+		if increment := buildVersionIncrement(table); increment != "" {
+			query += "," + increment
+		}
+		query += " WHERE " + table.PKColumn + "=?" + buildNotDeletedCondition(table)
+	} else {
+		query = "DELETE FROM " + table.Name + " WHERE " + table.PKColumn + "=?"
+	}
+	values := []interface{}{r.Id}
+	scopeCondition, scopeValues := buildScopeCondition(r)
+	query += scopeCondition
+	values = append(values, scopeValues...)
+	versionCondition, versions := buildVersionCondition(r, table)
+	query += versionCondition
+	values = append(values, versions...)
 	return query, values
 }
 
-func (MysqlQueryBuilder) BuildDeleteQuery(table *Table) string {
-	return "DELETE FROM " + table.Name + " WHERE " + table.PKColumn + "=?"
+func (MysqlQueryBuilder) BuildRestoreQuery(r Request, table *Table) (string, []interface{}) {
+	column := table.GetColumn(table.SoftDeleteColumn)
+	query := "UPDATE " + table.Name + " SET " + column.Name + "="
+	if column.IsTemporal() {
+		query += "NULL"
+	} else {
+		query += "0"
+	}
+	if increment := buildVersionIncrement(table); increment != "" {
+		query += "," + increment
+	}
+	query += " WHERE " + table.PKColumn + "=?"
+	values := []interface{}{r.Id}
+	scopeCondition, scopeValues := buildScopeCondition(r)
+	query += scopeCondition
+	values = append(values, scopeValues...)
+	versionCondition, versions := buildVersionCondition(r, table)
+	query += versionCondition
+	values = append(values, versions...)
+	return query, values
+}
+
+func (MysqlQueryBuilder) BuildAuditInsertQuery(auditTable string) string {
+	return "INSERT INTO " + auditTable + " (table_name,record_id,action,principal,before_data,after_data,created_at) VALUES (?,?,?,?,?,?,NOW())"
+}
+
+func (MysqlQueryBuilder) BuildHistoryQuery(auditTable string) string {
+	return "SELECT action, principal, before_data, after_data, created_at FROM " + auditTable + " WHERE table_name=? AND record_id=? ORDER BY id"
+}
+
+func (MysqlQueryBuilder) BuildAPIKeyLookupQuery(keyTable string) string {
+	return "SELECT id, principal, roles, grants FROM " + keyTable + " WHERE secret_hash=? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())"
+}
+
+func (MysqlQueryBuilder) BuildAPIKeyListQuery(keyTable string) string {
+	return "SELECT id, name, principal, roles, grants, expires_at, revoked_at, created_at FROM " + keyTable + " ORDER BY id"
+}
+
+func (MysqlQueryBuilder) BuildAPIKeyInsertQuery(keyTable string) string {
+	return "INSERT INTO " + keyTable + " (name,secret_hash,principal,roles,grants,expires_at,created_at) VALUES (?,?,?,?,?,?,NOW())"
+}
+
+func (MysqlQueryBuilder) BuildAPIKeyRevokeQuery(keyTable string) string {
+	return "UPDATE " + keyTable + " SET revoked_at=NOW() WHERE id=? AND revoked_at IS NULL"
+}
+
+func (MysqlQueryBuilder) BuildAPIKeyRotateQuery(keyTable string) string {
+	return "UPDATE " + keyTable + " SET secret_hash=? WHERE id=? AND revoked_at IS NULL"
+}
+
+func (MysqlQueryBuilder) BuildPaginatedQuery(query string, values []interface{}, limit, offset int64) (string, []interface{}) {
+	if limit < 0 {
+		// MySQL has no OFFSET without LIMIT
+		limit = math.MaxInt64
+	}
+	query = "SELECT * FROM (" + query + ") AS paginated LIMIT ? OFFSET ?"
+	return query, append(values, limit, offset)
+}
+
+func (MysqlQueryBuilder) BuildFunctionCallQuery(routine *Routine) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(routine.Parameters)), ",")
+	return "SELECT " + quoteIdentifier(routine.Name) + "(" + placeholders + ") AS result"
+}
+
+// BuildCallQuery calls a procedure, passing the session variable named in
+// variables for OUT and INOUT parameters and a placeholder for the others.
+func (MysqlQueryBuilder) BuildCallQuery(routine *Routine, variables []string) string {
+	arguments := make([]string, len(routine.Parameters))
+	for i := range routine.Parameters {
+		if variables[i] != "" {
+			arguments[i] = "@" + variables[i]
+		} else {
+			arguments[i] = "?"
+		}
+	}
+	return "CALL " + quoteIdentifier(routine.Name) + "(" + strings.Join(arguments, ",") + ")"
+}
+
+func (MysqlQueryBuilder) BuildSetVariableQuery(variable string) string {
+	return "SET @" + variable + "=?"
+}
+
+func (MysqlQueryBuilder) BuildSelectVariablesQuery(variables []string) string {
+	columns := make([]string, len(variables))
+	for i, variable := range variables {
+		columns[i] = "@" + variable + " AS " + variable
+	}
+	return "SELECT " + strings.Join(columns, ",")
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func buildNotDeletedCondition(t *Table) string {
+	column := t.GetColumn(t.SoftDeleteColumn)
+	if column == nil {
+		return ""
+	}
+	if column.IsTemporal() {
+		return " AND " + column.Name + " IS NULL"
+	}
+	return " AND COALESCE(" + column.Name + ",0)=0"
+}
+
+func buildScopeCondition(r Request) (string, []interface{}) {
+	condition := ""
+	values := make([]interface{}, 0)
+	for _, scope := range r.scope {
+		condition += " AND " + scope.column + "=?"
+		values = append(values, scope.value)
+	}
+	return condition, values
+}
+
+func buildVersionIncrement(t *Table) string {
+	if version := t.GetColumn(t.VersionColumn); version != nil && version.IsInteger() {
+		return version.Name + "=" + version.Name + "+1"
+	}
+	return ""
+}
+
+func buildVersionCondition(r Request, t *Table) (string, []interface{}) {
+	if t.VersionColumn == "" || r.IfMatch == "" || isWildcardETag(r.IfMatch) {
+		return "", nil
+	}
+	versions := versionsFromETags(r.IfMatch)
+	if len(versions) == 0 {
+		return "", nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(versions)), ",")
+	return " AND " + t.VersionColumn + " IN (" + placeholders + ")", versions
 }
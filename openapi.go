@@ -0,0 +1,327 @@
+package autorest
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SetOpenAPIInfo sets the title and version of the OpenAPI document served
+// at /rest/_openapi.json.
+func (s *Server) SetOpenAPIInfo(title, version string) {
+	s.openAPITitle = title
+	s.openAPIVersion = version
+}
+
+//go:generate go run fetch_swaggerui.go
+
+//go:embed swaggerui
+var swaggerUIAssets embed.FS
+
+// EnableSwaggerUI serves a Swagger UI page for the OpenAPI document at the
+// given path, e.g. /static/swagger/. The Swagger UI scripts and styles are
+// embedded in the binary and served from the same path, so the page doesn't
+// depend on a CDN.
+func (s *Server) EnableSwaggerUI(path string) {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	assets, _ := fs.Sub(swaggerUIAssets, "swaggerui")
+	page, err := fs.ReadFile(assets, "index.html")
+	if err != nil {
+		panic(err)
+	}
+	page = []byte(strings.Replace(string(page), "{{url}}", s.prefix+"_openapi.json", 1))
+	files := http.StripPrefix(path, http.FileServer(http.FS(assets)))
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path || r.URL.Path == path+"index.html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(page)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		s.respondWithError(ApiError{METHOD_NOT_SUPPORTED}, w)
+		return
+	}
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.respondWithError(err, w)
+		return
+	}
+	s.respond(OK, s.openAPIDocument(principal), w)
+}
+
+// openAPIDocument describes every table, named query and routine that is
+// not excluded and that the principal may use, as an OpenAPI 3.1 document.
+func (s *Server) openAPIDocument(principal *Principal) map[string]interface{} {
+	h := s.handler
+	paths := make(map[string]interface{})
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"message": map[string]interface{}{"type": "string"}},
+		},
+	}
+	for name, table := range h.tables {
+		if !h.HasTable(name) || !h.visibleTo(principal, name, GET_ALL, GET) {
+			continue
+		}
+		schemas[name] = tableSchema(table)
+		s.addTablePaths(paths, table)
+	}
+	for name, query := range h.queries {
+		if !h.visibleTo(principal, name, QUERY) {
+			continue
+		}
+		paths[s.prefix+"_query/"+name] = map[string]interface{}{"get": queryOperation(query)}
+	}
+	for name, routine := range h.routines {
+		if h.HasRoutine(name) && h.visibleTo(principal, name, RPC) {
+			paths[s.prefix+"_rpc/"+name] = map[string]interface{}{"post": routineOperation(routine)}
+		}
+	}
+	title, version := s.openAPITitle, s.openAPIVersion
+	if title == "" {
+		title = "autorest"
+	}
+	if version == "" {
+		version = "1.0.0"
+	}
+	document := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+	if securitySchemes := s.securitySchemes(); len(securitySchemes) > 0 {
+		document["components"].(map[string]interface{})["securitySchemes"] = securitySchemes
+		security := []interface{}{map[string]interface{}{}}
+		for _, name := range sortedKeys(securitySchemes) {
+			security = append(security, map[string]interface{}{name: []string{}})
+		}
+		document["security"] = security
+	}
+	return document
+}
+
+func (s *Server) addTablePaths(paths map[string]interface{}, table *Table) {
+	h := s.handler
+	name := table.Name
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	idParameter := map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "integer"}}
+	ifMatch := headerParameter("If-Match", "Only change the row if its ETag matches")
+	listParameters := append(filterParameters(table), paginationParameters()...)
+	itemParameters := []interface{}{idParameter}
+	if table.SoftDeleteColumn != "" {
+		includeDeleted := queryParameter("include_deleted", "Include soft-deleted rows, for privileged callers", map[string]interface{}{"type": "boolean"})
+		listParameters = append(listParameters, includeDeleted)
+		itemParameters = append(itemParameters, includeDeleted)
+	}
+	paths[s.prefix+name] = map[string]interface{}{
+		"get": operation("List "+name, name, listParameters, nil,
+			jsonResponse(OK, "The rows", map[string]interface{}{"type": "array", "items": ref}), BAD_REQUEST),
+		"post": operation("Create a row in "+name, name, nil, ref,
+			withHeaders(jsonResponse(CREATED, "The new row", ref), "Location", "ETag"), BAD_REQUEST),
+	}
+	paths[s.prefix+name+"/{id}"] = map[string]interface{}{
+		"get": operation("Get a row of "+name, name,
+			append(itemParameters, headerParameter("If-None-Match", "Respond with 304 if the row's ETag matches")), nil,
+			withHeaders(jsonResponse(OK, "The row", ref), "ETag"), NOT_FOUND),
+		"put": operation("Update a row of "+name, name, []interface{}{idParameter, ifMatch}, ref,
+			withHeaders(jsonResponse(OK, "The updated row", ref), "ETag"), BAD_REQUEST, NOT_FOUND, PRECONDITION_FAILED),
+		"delete": operation("Delete a row of "+name, name, []interface{}{idParameter, ifMatch}, nil,
+			map[string]interface{}{strconv.Itoa(NO_CONTENT): map[string]interface{}{"description": "The row was deleted"}}, NOT_FOUND, PRECONDITION_FAILED),
+	}
+	if table.SoftDeleteColumn != "" {
+		paths[s.prefix+name+"/{id}/_restore"] = map[string]interface{}{
+			"post": operation("Restore a soft-deleted row of "+name, name, []interface{}{idParameter, ifMatch}, nil,
+				jsonResponse(OK, "The restored row", ref), NOT_FOUND, PRECONDITION_FAILED),
+		}
+	}
+	if h.auditTable != "" {
+		paths[s.prefix+name+"/{id}/_history"] = map[string]interface{}{
+			"get": operation("Get the history of a row of "+name, name, []interface{}{idParameter}, nil,
+				jsonResponse(OK, "The changes to the row, oldest first", map[string]interface{}{"type": "array", "items": historySchema()}), NOT_FOUND),
+		}
+	}
+	for action := range h.customActions[name] {
+		paths[s.prefix+name+"/{id}/_"+action] = map[string]interface{}{
+			"post": operation("Run the "+action+" action on a row of "+name, name, []interface{}{idParameter},
+				map[string]interface{}{"type": "object"}, jsonResponse(OK, "The result of the action", map[string]interface{}{}), BAD_REQUEST, NOT_FOUND),
+		}
+	}
+}
+
+func filterParameters(table *Table) []interface{} {
+	parameters := make([]interface{}, 0)
+	for _, column := range table.Columns {
+		if !column.Access.Hidden && column.Access.Mask == nil {
+			parameters = append(parameters, queryParameter(column.Name, "Only rows whose "+column.Name+" contains the value", map[string]interface{}{"type": "string"}))
+		}
+	}
+	for _, field := range table.ComputedFields {
+		if field.SQL != "" {
+			parameters = append(parameters, queryParameter(field.Name, "Only rows whose "+field.Name+" contains the value", map[string]interface{}{"type": "string"}))
+		}
+	}
+	return append(parameters, queryParameter("sort", "Comma separated columns to sort by, prefixed with - for descending order", map[string]interface{}{"type": "string"}))
+}
+
+func paginationParameters() []interface{} {
+	return []interface{}{
+		queryParameter("limit", "The maximum number of rows", map[string]interface{}{"type": "integer", "minimum": 0}),
+		queryParameter("offset", "The number of rows to skip", map[string]interface{}{"type": "integer", "minimum": 0}),
+	}
+}
+
+func queryOperation(query *NamedQuery) map[string]interface{} {
+	parameters := make([]interface{}, 0, len(query.Parameters))
+	for _, parameter := range query.Parameters {
+		schema := typeSchema(parameter.Type)
+		if parameter.Default != nil {
+			schema["default"] = parameter.Default
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":     parameter.Name,
+			"in":       "query",
+			"required": parameter.Required && parameter.Default == nil,
+			"schema":   schema,
+		})
+	}
+	rows := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}
+	return operation("Run the "+query.Name+" query", "_query", append(parameters, paginationParameters()...), nil,
+		jsonResponse(OK, "The rows", rows), BAD_REQUEST, NOT_FOUND)
+}
+
+func routineOperation(routine *Routine) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for _, parameter := range routine.Parameters {
+		if parameter.Mode != "OUT" {
+			properties[parameter.Name] = typeSchema(parameter.Type)
+			required = append(required, parameter.Name)
+		}
+	}
+	arguments := map[string]interface{}{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+	var result map[string]interface{}
+	if routine.Type == FUNCTION {
+		result = map[string]interface{}{"type": "object", "properties": map[string]interface{}{"result": map[string]interface{}{}}}
+	} else {
+		result = map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+			"results": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}},
+			"out":     map[string]interface{}{"type": "object"},
+		}}
+	}
+	return operation("Call the "+routine.Name+" "+strings.ToLower(routine.Type), "_rpc", nil, arguments,
+		jsonResponse(OK, "The result of the call", result), BAD_REQUEST, NOT_FOUND)
+}
+
+func historySchema() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+		"action":      map[string]interface{}{"type": "string"},
+		"principal":   map[string]interface{}{"type": []interface{}{"string", "null"}},
+		"before_data": map[string]interface{}{},
+		"after_data":  map[string]interface{}{},
+		"created_at":  map[string]interface{}{"type": "string"},
+	}}
+}
+
+// operation describes an operation. Every operation may also fail with 401,
+// 403 and 429, and the given error status codes.
+func operation(summary, tag string, parameters []interface{}, body map[string]interface{}, responses map[string]interface{}, errorCodes ...int) map[string]interface{} {
+	for _, code := range append(errorCodes, UNAUTHORIZED, FORBIDDEN, TOO_MANY_REQUESTS) {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}},
+			},
+		}
+	}
+	result := map[string]interface{}{"summary": summary, "tags": []string{tag}, "responses": responses}
+	if len(parameters) > 0 {
+		result["parameters"] = parameters
+	}
+	if body != nil {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": body}},
+		}
+	}
+	return result
+}
+
+func jsonResponse(code int, description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		strconv.Itoa(code): map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+		},
+	}
+}
+
+func withHeaders(responses map[string]interface{}, headers ...string) map[string]interface{} {
+	for _, response := range responses {
+		described := make(map[string]interface{})
+		for _, header := range headers {
+			described[header] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		response.(map[string]interface{})["headers"] = described
+	}
+	return responses
+}
+
+func queryParameter(name, description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func headerParameter(name, description string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "header", "description": description, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func (s *Server) securitySchemes() map[string]interface{} {
+	schemes := make(map[string]interface{})
+	for _, authenticator := range s.authenticators {
+		switch a := authenticator.(type) {
+		case *APIKeyAuthenticator:
+			addAPIKeySchemes(schemes, a.Header, a.QueryParameter)
+		case *APIKeyStore:
+			addAPIKeySchemes(schemes, a.Header, a.QueryParameter)
+		case *BasicAuthenticator:
+			schemes["basic"] = map[string]interface{}{"type": "http", "scheme": "basic"}
+		case *JWTAuthenticator:
+			schemes["bearer"] = map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+		}
+	}
+	return schemes
+}
+
+func addAPIKeySchemes(schemes map[string]interface{}, header, queryParameter string) {
+	if header != "" {
+		schemes["apiKeyHeader"] = map[string]interface{}{"type": "apiKey", "in": "header", "name": header}
+	}
+	if queryParameter != "" {
+		schemes["apiKeyQuery"] = map[string]interface{}{"type": "apiKey", "in": "query", "name": queryParameter}
+	}
+}
+
+// visibleTo returns whether a principal is allowed any of the given actions on
+// a table, named query or routine, so that the OpenAPI document and JSON
+// schemas only describe what the caller can use.
+func (h *Handler) visibleTo(principal *Principal, name string, actions ...int) bool {
+	for _, action := range actions {
+		if h.authorize(Request{Table: name, Action: action, Principal: principal}) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package autorest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.ExcludeTables("products")
+	server.RegisterQuery("by_age", "SELECT * FROM users WHERE age = :age", QueryParameter{Name: "age", Type: "int", Required: true})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_openapi.json", nil))
+	if w.Code != OK {
+		t.Fatalf("Expected status code %d but got %d", OK, w.Code)
+	}
+	var document struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatalf("Could not parse the document: %s", err)
+	}
+	if document.OpenAPI != "3.1.0" {
+		t.Errorf("Expected OpenAPI 3.1.0 but got %s", document.OpenAPI)
+	}
+	for _, path := range []string{"/rest/users", "/rest/users/{id}", "/rest/accounts/{id}/_restore", "/rest/_query/by_age"} {
+		if _, ok := document.Paths[path]; !ok {
+			t.Errorf("Expected a path %s", path)
+		}
+	}
+	if _, ok := document.Paths["/rest/products"]; ok {
+		t.Error("Expected excluded tables to be left out")
+	}
+	if _, ok := document.Paths["/rest/users"]["delete"]; ok {
+		t.Error("Expected no DELETE on the list path")
+	}
+	if readOnly := document.Components.Schemas["orders"].Properties["version"]["readOnly"]; readOnly != true {
+		t.Errorf("Expected the version column to be read-only but got %v", readOnly)
+	}
+	cleanUp(server.handler)
+}
+
+func TestColumnSchema(t *testing.T) {
+	table := &Table{Name: "shirts", PKColumn: "id"}
+	column := &Column{Name: "size", Type: "enum", Nullable: true, MaxLength: 5, Enum: parseEnumValues("enum('small','it''s large')")}
+	expected := map[string]interface{}{
+		"type":      []interface{}{"string", "null"},
+		"enum":      []interface{}{"small", "it's large", nil},
+		"maxLength": int64(5),
+	}
+	if schema := columnSchema(table, column); !reflect.DeepEqual(schema, expected) {
+		t.Errorf("Expected %v but got %v", expected, schema)
+	}
+	id := &Column{Name: "id", Type: "int", AutoIncrement: true}
+	expected = map[string]interface{}{"type": "integer", "readOnly": true, "x-primaryKey": true}
+	if schema := columnSchema(table, id); !reflect.DeepEqual(schema, expected) {
+		t.Errorf("Expected %v but got %v", expected, schema)
+	}
+}
+
+func TestSwaggerUIIsServedWithoutCDN(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.EnableSwaggerUI("/static/swagger")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/static/swagger/", nil))
+	page := w.Body.String()
+	if w.Code != OK || !strings.Contains(page, `url: "/rest/_openapi.json"`) || strings.Contains(page, "https://") {
+		t.Errorf("Expected a page loading its assets from the same path but got %d %s", w.Code, page)
+	}
+	for _, asset := range []string{"swagger-ui-bundle.js", "swagger-ui.css", "LICENSE"} {
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/static/swagger/"+asset, nil))
+		if w.Code != OK || w.Body.Len() == 0 {
+			t.Errorf("Expected %s to be served but got %d", asset, w.Code)
+		}
+	}
+	cleanUp(server.handler)
+}
+
+func TestOpenAPIDocumentOnlyDescribesReadableTables(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	authenticator := NewAPIKeyAuthenticator("X-API-Key", "")
+	authenticator.AddKey("secret", &Principal{Id: "service", Roles: []string{"staff"}})
+	server.AddAuthenticator(authenticator)
+	server.Allow("products", GET_ALL)
+	server.AllowRole("staff", "users", GET)
+	for key, expected := range map[string][]string{
+		"":       {"/rest/products", "/rest/products/{id}"},
+		"secret": {"/rest/products", "/rest/products/{id}", "/rest/users", "/rest/users/{id}"},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/rest/_openapi.json", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		server.ServeHTTP(w, r)
+		var document struct {
+			Paths map[string]interface{} `json:"paths"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &document)
+		paths := make([]string, 0)
+		for path := range document.Paths {
+			if !strings.HasPrefix(path, "/rest/_") {
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Expected the paths %v for key %q but got %v", expected, key, paths)
+		}
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/rest/_schema/users", nil)
+	r.Header.Set("X-API-Key", "wrong")
+	server.ServeHTTP(w, r)
+	if w.Code != UNAUTHORIZED {
+		t.Errorf("Expected invalid credentials to fail with %d but got %d", UNAUTHORIZED, w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_schema/users", nil))
+	if w.Code != NOT_FOUND {
+		t.Errorf("Expected the schema of an unreadable table to fail with %d but got %d", NOT_FOUND, w.Code)
+	}
+	cleanUp(server.handler)
+}
@@ -0,0 +1,46 @@
+package autorest
+
+// OperationFunc implements an action on a table. It receives the Handler so
+// it can reuse its helpers, e.g. h.Get(db, r), and db is the transaction the
+// action runs in, if any, or the database itself.
+type OperationFunc func(h *Handler, r Request, table *Table, db Executor) (interface{}, error)
+
+// Override replaces the generated implementation of an action on a table.
+// Authorization, row filters and hooks still apply. Writes run in the same
+// transaction as the audit log and hooks, if those are enabled.
+func (s *Server) Override(table string, action int, operation OperationFunc) {
+	s.handler.mustGetTable(table)
+	if s.handler.overrides == nil {
+		s.handler.overrides = make(map[string]map[int]OperationFunc)
+	}
+	if s.handler.overrides[table] == nil {
+		s.handler.overrides[table] = make(map[int]OperationFunc)
+	}
+	s.handler.overrides[table][action] = operation
+}
+
+// RegisterAction adds a custom action served at POST /rest/table/:id/_name.
+// Custom actions always run in a transaction, and are allowed with the
+// CUSTOM action in policies and API key grants.
+func (s *Server) RegisterAction(table, name string, operation OperationFunc) {
+	s.handler.mustGetTable(table)
+	if !identifierPattern.MatchString(name) {
+		panic("Invalid action name " + name)
+	}
+	if s.handler.customActions == nil {
+		s.handler.customActions = make(map[string]map[string]OperationFunc)
+	}
+	if s.handler.customActions[table] == nil {
+		s.handler.customActions[table] = make(map[string]OperationFunc)
+	}
+	s.handler.customActions[table][name] = operation
+}
+
+// operationFor returns the override or custom action for a request, or nil if
+// the generated implementation should be used.
+func (h *Handler) operationFor(r Request) OperationFunc {
+	if r.Action == CUSTOM {
+		return h.customActions[r.Table][r.CustomAction]
+	}
+	return h.overrides[r.Table][r.Action]
+}
@@ -0,0 +1,69 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.Override("products", GET, func(h *Handler, r Request, table *Table, db Executor) (interface{}, error) {
+		if table.Name != "products" || db != Executor(h.db) {
+			t.Errorf("Expected the products table and the database but got %s and %v", table.Name, db)
+		}
+		return map[string]interface{}{"id": r.Id, "name": "custom"}, nil
+	})
+	result, err := server.handler.HandleRequest(Request{Table: "products", Action: GET, Id: 3})
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkKeyAndValue(t, "name", "custom", result.(map[string]interface{}))
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestCustomAction(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.RegisterAction("orders", "cancel", func(h *Handler, r Request, table *Table, db Executor) (interface{}, error) {
+		if _, err := h.exec(r.context(), db, "UPDATE orders SET status='cancelled' WHERE id=?", []interface{}{r.Id}); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"id": r.Id, "reason": r.Data["reason"]}, nil
+	})
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE orders SET status='cancelled' WHERE id=\\?").
+		ExpectExec().
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rest/orders/1/_cancel", strings.NewReader("{\"reason\":\"duplicate\"}"))
+	server.ServeHTTP(w, r)
+	if w.Code != OK {
+		t.Errorf("Expected status code %d but got %d", OK, w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/rest/orders/1/_refund", nil))
+	if w.Code != NOT_FOUND {
+		t.Errorf("Expected status code %d but got %d", NOT_FOUND, w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/orders/1/_cancel", nil))
+	if w.Code != METHOD_NOT_SUPPORTED {
+		t.Errorf("Expected status code %d but got %d", METHOD_NOT_SUPPORTED, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestCustomActionIsAWrite(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.RegisterAction("orders", "cancel", func(h *Handler, r Request, table *Table, db Executor) (interface{}, error) {
+		return nil, nil
+	})
+	server.SetReadOnly(true)
+	checkAuthorization(t, server.handler, Request{Table: "orders", Action: CUSTOM, CustomAction: "cancel", Id: 1}, FORBIDDEN)
+	cleanUp(server.handler)
+}
@@ -0,0 +1,110 @@
+package autorest
+
+const ALL_TABLES = "*"
+
+type grants map[string]map[int]bool
+
+func (g grants) add(table string, actions []int) {
+	if g[table] == nil {
+		g[table] = make(map[int]bool)
+	}
+	for _, action := range actions {
+		g[table][action] = true
+	}
+}
+
+func (g grants) allows(table string, action int) bool {
+	return g[table][action] || g[ALL_TABLES][action]
+}
+
+// policy holds the rules added with Server.Allow and Server.AllowRole. Once
+// any rule exists, requests that no rule allows are denied.
+type policy struct {
+	everyone grants
+	roles    map[string]grants
+}
+
+func newPolicy() *policy {
+	return &policy{everyone: make(grants), roles: make(map[string]grants)}
+}
+
+func (p *policy) allowsEveryone(table string, action int) bool {
+	return p != nil && p.everyone.allows(table, action)
+}
+
+func (p *policy) allows(principal *Principal, table string, action int) bool {
+	if p == nil {
+		return true
+	}
+	if p.everyone.allows(table, action) {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, role := range principal.Roles {
+		if p.roles[role].allows(table, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWriteAction(action int) bool {
+	switch action {
+	case POST, PUT, DELETE, RESTORE, CUSTOM, RPC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Handler) authorize(r Request) error {
+	if h.readOnly && isWriteAction(r.Action) {
+		return ApiError{FORBIDDEN}
+	}
+	if r.Principal != nil && r.Principal.grants != nil && !r.Principal.grants.allows(r.Table, r.Action) {
+		h.logger.Info("API key of " + r.Principal.Id + " was not granted " + actionNames[r.Action] + " on " + r.Table)
+		return ApiError{FORBIDDEN}
+	}
+	if !h.policy.allowsEveryone(r.Table, r.Action) {
+		if h.requireAuthentication && r.Principal == nil {
+			return ApiError{UNAUTHORIZED}
+		}
+		if !h.policy.allows(r.Principal, r.Table, r.Action) {
+			h.logger.Info("Denied " + actionNames[r.Action] + " on " + r.Table)
+			return ApiError{FORBIDDEN}
+		}
+	}
+	if r.IncludeDeleted && !r.privileged {
+		return ApiError{FORBIDDEN}
+	}
+	return nil
+}
+
+// Allow lets every caller, authenticated or not, perform the given actions on
+// a table. Use ALL_TABLES to allow the actions on every table.
+func (s *Server) Allow(table string, actions ...int) {
+	if s.handler.policy == nil {
+		s.handler.policy = newPolicy()
+	}
+	s.handler.policy.everyone.add(table, actions)
+}
+
+// AllowRole lets callers with the given role perform the given actions on a
+// table. Use ALL_TABLES to allow the actions on every table.
+func (s *Server) AllowRole(role, table string, actions ...int) {
+	if s.handler.policy == nil {
+		s.handler.policy = newPolicy()
+	}
+	if s.handler.policy.roles[role] == nil {
+		s.handler.policy.roles[role] = make(grants)
+	}
+	s.handler.policy.roles[role].add(table, actions)
+}
+
+// SetReadOnly disables POST, PUT, DELETE, restores and custom actions on
+// every table.
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.handler.readOnly = readOnly
+}
@@ -0,0 +1,51 @@
+package autorest
+
+import (
+	"testing"
+)
+
+func checkAuthorization(t *testing.T, handler *Handler, r Request, expectedStatusCode int) {
+	err := handler.authorize(r)
+	if expectedStatusCode == OK {
+		if err != nil {
+			t.Errorf("Expected %s on %s to be allowed but got %v", actionNames[r.Action], r.Table, err)
+		}
+	} else if err == nil || err.(ApiError).HTTPStatusCode != expectedStatusCode {
+		t.Errorf("Expected %s on %s to fail with %d but got %v", actionNames[r.Action], r.Table, expectedStatusCode, err)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.Allow("products", GET, GET_ALL)
+	server.AllowRole("editor", "users", GET, PUT)
+	server.AllowRole("admin", ALL_TABLES, GET, GET_ALL, POST, PUT, DELETE)
+	editor := &Principal{Id: "alice", Roles: []string{"editor"}}
+	admin := &Principal{Id: "bob", Roles: []string{"admin"}}
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: GET_ALL}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: POST}, FORBIDDEN)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: PUT, Principal: editor}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: DELETE, Principal: editor}, FORBIDDEN)
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: DELETE, Principal: admin}, OK)
+	cleanUp(server.handler)
+}
+
+func TestPoliciesWithAuthentication(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.AddAuthenticator(NewAPIKeyAuthenticator("X-API-Key", ""))
+	server.Allow("products", GET_ALL)
+	server.AllowRole("editor", "users", PUT)
+	checkAuthorization(t, server.handler, Request{Table: "products", Action: GET_ALL}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: PUT}, UNAUTHORIZED)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: PUT, Principal: &Principal{Id: "alice"}}, FORBIDDEN)
+	cleanUp(server.handler)
+}
+
+func TestReadOnly(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.SetReadOnly(true)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: GET}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: POST}, FORBIDDEN)
+	checkAuthorization(t, server.handler, Request{Table: "users", Action: DELETE}, FORBIDDEN)
+	cleanUp(server.handler)
+}
@@ -0,0 +1,28 @@
+package autorest
+
+// Principal is the caller a request is made on behalf of.
+type Principal struct {
+	Id         string
+	Roles      []string
+	Attributes map[string]interface{}
+	grants     grants
+}
+
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func principalId(p *Principal) interface{} {
+	if p == nil {
+		return nil
+	}
+	return p.Id
+}
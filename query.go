@@ -0,0 +1,202 @@
+package autorest
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QueryParameter describes a parameter of a named query. Type is a MySQL data
+// type such as int, decimal or varchar and defaults to varchar. Parameters
+// without a Default must be given unless they are Required: false, in which
+// case they are NULL.
+type QueryParameter struct {
+	Name     string
+	Type     string
+	Required bool
+	Default  interface{}
+}
+
+// NamedQuery is a parameterized SQL statement served at GET /rest/_query/name.
+type NamedQuery struct {
+	Name       string
+	SQL        string
+	Parameters []QueryParameter
+
+	statement    string
+	placeholders []string
+}
+
+// RegisterQuery serves a SQL statement, which may also CALL a stored
+// procedure, at GET /rest/_query/name. Parameters are written as :name in the
+// statement and are read from the query string. Parameters that are used but
+// not described are required strings. Queries are authorized like tables, by
+// their name, with the QUERY action, and support limit and offset.
+func (s *Server) RegisterQuery(name, sql string, parameters ...QueryParameter) *NamedQuery {
+	if !identifierPattern.MatchString(name) {
+		panic("Invalid query name " + name)
+	}
+	statement, placeholders := parseNamedParameters(strings.TrimRight(strings.TrimSpace(sql), ";"))
+	query := &NamedQuery{Name: name, SQL: sql, statement: statement, placeholders: placeholders}
+	described := make(map[string]bool)
+	for _, parameter := range parameters {
+		described[parameter.Name] = true
+		query.Parameters = append(query.Parameters, parameter)
+	}
+	for _, placeholder := range placeholders {
+		if placeholder == "limit" || placeholder == "offset" {
+			panic("Query " + name + " may not have a parameter called " + placeholder)
+		}
+		if !described[placeholder] {
+			described[placeholder] = true
+			query.Parameters = append(query.Parameters, QueryParameter{Name: placeholder, Required: true})
+		}
+	}
+	if s.handler.queries == nil {
+		s.handler.queries = make(map[string]*NamedQuery)
+	}
+	s.handler.queries[name] = query
+	return query
+}
+
+// parseNamedParameters replaces :name parameters with ? outside of quoted
+// strings and identifiers, returning the names in the order they appear.
+func parseNamedParameters(sql string) (string, []string) {
+	var statement strings.Builder
+	placeholders := make([]string, 0)
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(sql) {
+				statement.WriteByte(c)
+				i++
+				c = sql[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(sql) && isIdentifierByte(sql[i+1]) && (i == 0 || sql[i-1] != ':'):
+			end := i + 1
+			for end < len(sql) && isIdentifierByte(sql[end]) {
+				end++
+			}
+			placeholders = append(placeholders, sql[i+1:end])
+			statement.WriteByte('?')
+			i = end - 1
+			continue
+		}
+		statement.WriteByte(c)
+	}
+	return statement.String(), placeholders
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p QueryParameter) parse(value string) (interface{}, error) {
+	switch strings.ToLower(p.Type) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return strconv.ParseInt(value, 10, 64)
+	case "decimal", "numeric", "float", "double":
+		return strconv.ParseFloat(value, 64)
+	case "bool", "boolean":
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+func (q *NamedQuery) values(r Request) ([]interface{}, error) {
+	parameters := make(map[string]interface{})
+	for _, parameter := range q.Parameters {
+		raw, ok := r.QueryParameters[parameter.Name].(string)
+		switch {
+		case ok:
+			value, err := parameter.parse(raw)
+			if err != nil {
+				return nil, ApiError{BAD_REQUEST}
+			}
+			parameters[parameter.Name] = value
+		case parameter.Default != nil:
+			parameters[parameter.Name] = parameter.Default
+		case parameter.Required:
+			return nil, ApiError{BAD_REQUEST}
+		default:
+			parameters[parameter.Name] = nil
+		}
+	}
+	values := make([]interface{}, len(q.placeholders))
+	for i, placeholder := range q.placeholders {
+		values[i] = parameters[placeholder]
+	}
+	return values, nil
+}
+
+// isSelect reports whether the query can be paginated in SQL, rather than
+// after it has been run.
+func (q *NamedQuery) isSelect() bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(q.statement)), "SELECT")
+}
+
+func (h *Handler) HasQuery(name string) bool {
+	_, ok := h.queries[name]
+	return ok
+}
+
+func (h *Handler) RunQuery(db Executor, r Request) (interface{}, error) {
+	query, ok := h.queries[r.Table]
+	if !ok {
+		return nil, ApiError{NOT_FOUND}
+	}
+	values, err := query.values(r)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		return nil, err
+	}
+	statement := query.statement
+	if query.isSelect() && (limit >= 0 || offset > 0) {
+		statement, values = h.queryBuilder.BuildPaginatedQuery(statement, values, limit, offset)
+	}
+	rows, err := h.query(r.context(), db, statement, values)
+	if err != nil {
+		return nil, err
+	}
+	if !query.isSelect() {
+		rows = paginate(rows, limit, offset)
+	}
+	return rows, nil
+}
+
+// parsePagination reads the limit and offset query parameters. A limit of -1
+// means there is none.
+func parsePagination(r Request) (limit int64, offset int64, err error) {
+	limit, offset = -1, 0
+	if value, ok := r.QueryParameters["limit"].(string); ok {
+		if limit, err = strconv.ParseInt(value, 10, 64); err != nil || limit < 0 {
+			return 0, 0, ApiError{BAD_REQUEST}
+		}
+	}
+	if value, ok := r.QueryParameters["offset"].(string); ok {
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil || offset < 0 {
+			return 0, 0, ApiError{BAD_REQUEST}
+		}
+	}
+	return limit, offset, nil
+}
+
+func paginate(rows []map[string]interface{}, limit, offset int64) []map[string]interface{} {
+	if offset >= int64(len(rows)) {
+		return rows[:0]
+	}
+	rows = rows[offset:]
+	if limit >= 0 && limit < int64(len(rows)) {
+		rows = rows[:limit]
+	}
+	return rows
+}
@@ -0,0 +1,65 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseNamedParameters(t *testing.T) {
+	statement, placeholders := parseNamedParameters("SELECT * FROM t WHERE a = :a AND b = ':b' AND c::int = :c_2 AND d = :a")
+	if statement != "SELECT * FROM t WHERE a = ? AND b = ':b' AND c::int = ? AND d = ?" {
+		t.Errorf("Unexpected statement %s", statement)
+	}
+	if len(placeholders) != 3 || placeholders[0] != "a" || placeholders[1] != "c_2" || placeholders[2] != "a" {
+		t.Errorf("Unexpected placeholders %v", placeholders)
+	}
+}
+
+func TestNamedQuery(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.RegisterQuery("top_customers", "SELECT name FROM users WHERE region = :region AND age > :age;",
+		QueryParameter{Name: "age", Type: "int", Default: int64(18)})
+	mock.ExpectPrepare("SELECT \\* FROM \\(SELECT name FROM users WHERE region = \\? AND age > \\?\\) AS paginated LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs("eu", 18, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow([]byte("Ann")))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_query/top_customers?region=eu&limit=10&offset=20", nil))
+	if w.Code != OK {
+		t.Errorf("Expected status code %d but got %d", OK, w.Code)
+	}
+	if body := w.Body.String(); body != "[{\"name\":\"Ann\"}]" {
+		t.Errorf("Unexpected body %s", body)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestNamedQueryValidatesParameters(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.RegisterQuery("by_age", "SELECT * FROM users WHERE age = :age", QueryParameter{Name: "age", Type: "int", Required: true})
+	for _, path := range []string{"/rest/_query/by_age", "/rest/_query/by_age?age=old", "/rest/_query/by_age?age=1&limit=-1"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != BAD_REQUEST {
+			t.Errorf("Expected status code %d for %s but got %d", BAD_REQUEST, path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/_query/unknown", nil))
+	if w.Code != NOT_FOUND {
+		t.Errorf("Expected status code %d but got %d", NOT_FOUND, w.Code)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestNamedQueryIsAuthorizedByName(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.RegisterQuery("report", "CALL monthly_report()")
+	server.Allow("report", QUERY)
+	checkAuthorization(t, server.handler, Request{Table: "report", Action: QUERY}, OK)
+	checkAuthorization(t, server.handler, Request{Table: "other", Action: QUERY}, FORBIDDEN)
+	cleanUp(server.handler)
+}
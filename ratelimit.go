@@ -0,0 +1,229 @@
+package autorest
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests requests per period Per, with bursts of up to
+// Requests requests.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+const ALL_ACTIONS = -1
+
+var defaultAuthenticationRateLimit = RateLimit{Requests: 20, Per: time.Minute}
+
+// RateLimitKeyFunc decides which requests share a rate limit.
+type RateLimitKeyFunc func(r *http.Request) string
+
+func RateLimitByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByPrincipal limits authenticated callers by their id and everyone
+// else by their IP address.
+func RateLimitByPrincipal(r *http.Request) string {
+	if principal := PrincipalFromRequest(r); principal != nil {
+		return "principal:" + principal.Id
+	}
+	return "ip:" + RateLimitByIP(r)
+}
+
+// RateLimitByAPIKey limits callers by the API key in the given header, and
+// callers without one by their IP address.
+func RateLimitByAPIKey(header string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if key := r.Header.Get(header); key != "" {
+			return "key:" + hashAPIKey(key)
+		}
+		return "ip:" + RateLimitByIP(r)
+	}
+}
+
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	rate := float64(b.limit.Requests) / b.limit.Per.Seconds()
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+func (b *tokenBucket) secondsUntil(tokens float64) int {
+	rate := float64(b.limit.Requests) / b.limit.Per.Seconds()
+	return int(math.Ceil(math.Max(0, tokens-b.tokens) / rate))
+}
+
+type rateLimiter struct {
+	mutex        sync.Mutex
+	defaultLimit RateLimit
+	limits       map[string]map[int]RateLimit
+	keyFunc      RateLimitKeyFunc
+	failureLimit RateLimit
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		limits:       make(map[string]map[int]RateLimit),
+		keyFunc:      RateLimitByPrincipal,
+		failureLimit: defaultAuthenticationRateLimit,
+		buckets:      make(map[string]*tokenBucket),
+		lastSweep:    time.Now(),
+	}
+}
+
+// limitFor finds the most specific limit for a request. Limits for a table
+// and action come first, then limits for the whole table, then limits for
+// the action on all tables, and finally the default limit.
+func (l *rateLimiter) limitFor(table string, action int) (string, RateLimit) {
+	for _, candidate := range []struct {
+		table  string
+		action int
+	}{{table, action}, {table, ALL_ACTIONS}, {ALL_TABLES, action}} {
+		if limit, ok := l.limits[candidate.table][candidate.action]; ok {
+			return candidate.table + "|" + strconv.Itoa(candidate.action), limit
+		}
+	}
+	return "", l.defaultLimit
+}
+
+func (l *rateLimiter) allow(r *http.Request, table string, action int, w http.ResponseWriter) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rule, limit := l.limitFor(table, action)
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		return nil
+	}
+	bucket := l.bucket(l.keyFunc(r)+"|"+rule, limit, time.Now())
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(bucket.tokens)))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(bucket.secondsUntil(float64(limit.Requests))))
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(bucket.secondsUntil(1)))
+		return ApiError{TOO_MANY_REQUESTS}
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket with the given key, creating it if it
+// doesn't exist yet or its limit has changed.
+func (l *rateLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Requests), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.refill(now)
+	return bucket
+}
+
+// failureBucket returns the bucket of failed authentications of a client's
+// IP address, or nil if they aren't limited.
+func (l *rateLimiter) failureBucket(r *http.Request) *tokenBucket {
+	if l.failureLimit.Requests <= 0 || l.failureLimit.Per <= 0 {
+		return nil
+	}
+	return l.bucket("authentication|"+RateLimitByIP(r), l.failureLimit, time.Now())
+}
+
+// allowAuthentication rejects clients that have failed to authenticate too
+// often, before their credentials are checked. It doesn't charge for the
+// attempt; failures are charged by chargeFailedAuthentication.
+func (l *rateLimiter) allowAuthentication(r *http.Request, w http.ResponseWriter) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	bucket := l.failureBucket(r)
+	if bucket == nil || bucket.tokens >= 1 {
+		return nil
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(bucket.secondsUntil(1)))
+	return ApiError{TOO_MANY_REQUESTS}
+}
+
+func (l *rateLimiter) chargeFailedAuthentication(r *http.Request) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if bucket := l.failureBucket(r); bucket != nil && bucket.tokens >= 1 {
+		bucket.tokens--
+	}
+}
+
+// sweep forgets buckets that have been idle long enough to be full again.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) > bucket.limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// SetRateLimit sets the limit for requests that no table or action specific
+// limit applies to. A zero RateLimit removes the limit. Rate limits can be
+// changed while the server is running.
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.rateLimiter.mutex.Lock()
+	defer s.rateLimiter.mutex.Unlock()
+	s.rateLimiter.defaultLimit = limit
+}
+
+// SetTableRateLimit limits the given actions on a table, or all actions if
+// none are given. Use ALL_TABLES to limit actions on every table.
+func (s *Server) SetTableRateLimit(table string, limit RateLimit, actions ...int) {
+	s.rateLimiter.mutex.Lock()
+	defer s.rateLimiter.mutex.Unlock()
+	if len(actions) == 0 {
+		actions = []int{ALL_ACTIONS}
+	}
+	if s.rateLimiter.limits[table] == nil {
+		s.rateLimiter.limits[table] = make(map[int]RateLimit)
+	}
+	for _, action := range actions {
+		if limit.Requests <= 0 {
+			delete(s.rateLimiter.limits[table], action)
+		} else {
+			s.rateLimiter.limits[table][action] = limit
+		}
+	}
+}
+
+// SetAuthenticationRateLimit limits how often a client, by IP address, may
+// fail to authenticate. Clients over the limit receive 429 before their
+// credentials are checked, so guessing passwords or API keys is slowed down
+// and doesn't cost a lookup each time. The default is 20 failures a minute,
+// and a zero RateLimit removes the limit.
+func (s *Server) SetAuthenticationRateLimit(limit RateLimit) {
+	s.rateLimiter.mutex.Lock()
+	defer s.rateLimiter.mutex.Unlock()
+	s.rateLimiter.failureLimit = limit
+}
+
+func (s *Server) SetRateLimitKey(keyFunc RateLimitKeyFunc) {
+	s.rateLimiter.mutex.Lock()
+	defer s.rateLimiter.mutex.Unlock()
+	s.rateLimiter.keyFunc = keyFunc
+}
@@ -0,0 +1,71 @@
+package autorest
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	server.SetRateLimit(RateLimit{Requests: 100, Per: time.Minute})
+	server.SetTableRateLimit("products", RateLimit{Requests: 2, Per: time.Minute}, GET_ALL)
+	r := httptest.NewRequest("GET", "/rest/products", nil)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		if err := server.rateLimiter.allow(r, "products", GET_ALL, w); err != nil {
+			t.Fatalf("Expected request %d to be allowed but got %v", i+1, err)
+		}
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Errorf("Expected X-RateLimit-Limit 2 but got %s", limit)
+		}
+	}
+	w := httptest.NewRecorder()
+	err := server.rateLimiter.allow(r, "products", GET_ALL, w)
+	if err == nil || err.(ApiError).HTTPStatusCode != TOO_MANY_REQUESTS {
+		t.Errorf("Expected a 429 error but got %v", err)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Expected Retry-After 30 but got %s", retryAfter)
+	}
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("Expected X-RateLimit-Remaining 0 but got %s", remaining)
+	}
+	if err := server.rateLimiter.allow(r, "products", GET, httptest.NewRecorder()); err != nil {
+		t.Errorf("Expected other actions to use the default limit but got %v", err)
+	}
+	other := httptest.NewRequest("GET", "/rest/products", nil)
+	other.RemoteAddr = "10.0.0.1:1234"
+	if err := server.rateLimiter.allow(other, "products", GET_ALL, httptest.NewRecorder()); err != nil {
+		t.Errorf("Expected other clients to have their own limit but got %v", err)
+	}
+	cleanUp(server.handler)
+}
+
+func TestFailedAuthenticationIsRateLimited(t *testing.T) {
+	server, _ := getServerForTesting(t)
+	authenticator := NewAPIKeyAuthenticator("X-API-Key", "")
+	authenticator.AddKey("secret", &Principal{Id: "service"})
+	server.AddAuthenticator(authenticator)
+	server.SetAuthenticationRateLimit(RateLimit{Requests: 2, Per: time.Minute})
+	for i, expected := range []int{UNAUTHORIZED, UNAUTHORIZED, TOO_MANY_REQUESTS} {
+		r := httptest.NewRequest("GET", "/rest/_schema/users", nil)
+		r.Header.Set("X-API-Key", "guess"+strconv.Itoa(i))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Errorf("Expected guess %d to fail with %d but got %d", i+1, expected, w.Code)
+		}
+	}
+	r := httptest.NewRequest("GET", "/graphql/schema", nil)
+	r.Header.Set("X-API-Key", "secret")
+	if _, err := server.authenticate(httptest.NewRecorder(), r); err == nil || err.(ApiError).HTTPStatusCode != TOO_MANY_REQUESTS {
+		t.Errorf("Expected the client to be blocked even with a valid key but got %v", err)
+	}
+	r.RemoteAddr = "10.0.0.1:1234"
+	if principal, err := server.authenticate(httptest.NewRecorder(), r); err != nil || principal == nil {
+		t.Errorf("Expected other clients to be allowed but got %v", err)
+	}
+	cleanUp(server.handler)
+}
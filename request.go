@@ -1,6 +1,7 @@
 package autorest
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -13,72 +14,161 @@ const (
 	POST
 	PUT
 	DELETE
+	RESTORE
+	HISTORY
+	CUSTOM
+	QUERY
+	RPC
 )
 
-type request struct {
+var actionNames = map[int]string{
+	GET:     "GET",
+	GET_ALL: "GET_ALL",
+	POST:    "POST",
+	PUT:     "PUT",
+	DELETE:  "DELETE",
+	RESTORE: "RESTORE",
+	HISTORY: "HISTORY",
+	CUSTOM:  "CUSTOM",
+	QUERY:   "QUERY",
+	RPC:     "RPC",
+}
+
+// Request is a parsed request for a table. It is available to middleware
+// through RequestFromContext.
+type Request struct {
 	Table  string
 	Action int
 	Id     int64
 	Data   map[string]interface{}
 	QueryParameters map[string]interface{}
+	IfMatch     string
+	IfNoneMatch string
+	IncludeDeleted bool
+	CustomAction string
+	Principal *Principal
 	hasId  bool
+	privileged bool
+	scope []scopeValue
+	equal []scopeValue
+	unrestricted bool
+	ctx context.Context
+	stream rowStream
 }
 
-func parseRequest(r *http.Request) (request, error) {
-	parts := strings.Split(r.URL.Path, "/")[1:]
-	if len(parts) < 2 {
-		return request{}, ApiError{404}
+// parseRequest parses a request whose path starts with the given prefix, e.g.
+// /rest/users/1 for the prefix /rest/.
+func parseRequest(r *http.Request, prefix string) (Request, error) {
+	parts := pathParts(r, prefix)
+	if len(parts) < 1 || parts[0] == "" {
+		return Request{}, ApiError{404}
 	}
-	method, err := getMethod(r)
+	if parts[0] == "_query" {
+		return parseQueryRequest(r, parts)
+	}
+	if parts[0] == "_rpc" {
+		return parseRPCRequest(r, parts)
+	}
+	method, err := getMethod(r, parts)
 	if err != nil {
-		return request{}, err
+		return Request{}, err
 	}
-	id, err, hasId := parseIdFromRequest(r)
+	id, err, hasId := parseIdFromPath(parts)
 	if err != nil {
-		return request{}, err
+		return Request{}, err
 	}
 	var data map[string]interface{}
-	if method == POST || method == PUT {
+	if method == POST || method == PUT || (method == CUSTOM && r.ContentLength != 0) {
 		data, err = parseDataFromRequest(r)
 		if err != nil {
-			return request{}, err
+			return Request{}, err
 		}
 	}
 	queryParameters, err := parseQueryParameters(r)
 	if err != nil {
-		return request{}, err
+		return Request{}, err
 	}
-	return request{
+	return Request{
 		Id: id,
-		Table: parts[1],
+		Table: parts[0],
 		Action: method,
 		Data: data,
 		QueryParameters: queryParameters,
+		IfMatch: r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+		IncludeDeleted: queryParameters["include_deleted"] == "true",
+		CustomAction: parseCustomActionName(parts),
 		hasId: hasId,
+		ctx: r.Context(),
 	}, nil
 }
 
-func getMethod(r *http.Request) (int, error) {
+// parseQueryRequest parses a request for a named query, e.g.
+// /rest/_query/top_customers. The query name takes the place of the table.
+func parseQueryRequest(r *http.Request, parts []string) (Request, error) {
+	if len(parts) != 2 || parts[1] == "" {
+		return Request{}, ApiError{NOT_FOUND}
+	}
+	if strings.ToUpper(r.Method) != "GET" {
+		return Request{}, ApiError{METHOD_NOT_SUPPORTED}
+	}
+	queryParameters, err := parseQueryParameters(r)
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{
+		Table: parts[1],
+		Action: QUERY,
+		QueryParameters: queryParameters,
+		ctx: r.Context(),
+	}, nil
+}
+
+func pathParts(r *http.Request, prefix string) []string {
+	return strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+}
+
+// context returns the context of the HTTP request, which is cancelled when the
+// client goes away, or the background context for requests built in code.
+func (r Request) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r Request) actionName() string {
+	if r.Action == CUSTOM {
+		return r.CustomAction
+	}
+	return actionNames[r.Action]
+}
+
+func getMethod(r *http.Request, parts []string) (int, error) {
 	method := strings.ToUpper(r.Method)
+	if action := parseActionFromPath(parts); action != "" {
+		return getActionMethod(method, action)
+	}
+	_, _, hasId := parseIdFromPath(parts)
 	switch method {
 	case "GET":
-		if _, _, hasId := parseIdFromRequest(r); hasId {
+		if hasId {
 			return GET, nil
 		} else {
 			return GET_ALL, nil
 		}
 	case "POST":
-		if _, _, hasId := parseIdFromRequest(r); hasId {
+		if hasId {
 			return -1, ApiError{BAD_REQUEST}
 		}
 		return POST, nil
 	case "PUT":
-		if _, _, hasId := parseIdFromRequest(r); !hasId {
+		if !hasId {
 			return -1, ApiError{BAD_REQUEST}
 		}
 		return PUT, nil
 	case "DELETE":
-		if _, _, hasId := parseIdFromRequest(r); !hasId {
+		if !hasId {
 			return -1, ApiError{BAD_REQUEST}
 		}
 		return DELETE, nil
@@ -87,12 +177,52 @@ func getMethod(r *http.Request) (int, error) {
 	}
 }
 
-func parseIdFromRequest(r *http.Request) (int64, error, bool) {
-	parts := strings.Split(r.URL.Path, "/")[1:]
+func getActionMethod(method, action string) (int, error) {
+	switch action {
+	case "_restore":
+		if method != "POST" {
+			return -1, ApiError{METHOD_NOT_SUPPORTED}
+		}
+		return RESTORE, nil
+	case "_history":
+		if method != "GET" {
+			return -1, ApiError{METHOD_NOT_SUPPORTED}
+		}
+		return HISTORY, nil
+	default:
+		if !strings.HasPrefix(action, "_") {
+			return -1, ApiError{NOT_FOUND}
+		}
+		if method != "POST" {
+			return -1, ApiError{METHOD_NOT_SUPPORTED}
+		}
+		return CUSTOM, nil
+	}
+}
+
+// parseCustomActionName returns the name of a custom action, e.g. cancel for
+// /rest/orders/1/_cancel.
+func parseCustomActionName(parts []string) string {
+	switch action := parseActionFromPath(parts); action {
+	case "_restore", "_history":
+		return ""
+	default:
+		return strings.TrimPrefix(action, "_")
+	}
+}
+
+func parseActionFromPath(parts []string) string {
 	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+func parseIdFromPath(parts []string) (int64, error, bool) {
+	if len(parts) < 2 {
 		return 0, nil, false
 	}
-	id, err := strconv.ParseInt(parts[2], 10, 64)
+	id, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
 		return 0, ApiError{BAD_REQUEST}, false
 	}
@@ -0,0 +1,68 @@
+package autorest
+
+import (
+	"errors"
+)
+
+// RowFilterFunc returns the value a column must have for rows to be visible
+// to, and writable by, the given principal.
+type RowFilterFunc func(principal *Principal) (interface{}, error)
+
+type RowFilter struct {
+	Column string
+	Value  RowFilterFunc
+}
+
+type scopeValue struct {
+	column string
+	value  interface{}
+}
+
+// RestrictRows limits every read and write on a table to the rows whose
+// column equals the value returned by filter for the caller. The column is
+// also forced to that value on POST and cannot be changed with PUT.
+func (s *Server) RestrictRows(tableName, columnName string, filter RowFilterFunc) {
+	table := s.handler.mustGetTable(tableName)
+	if !table.HasColumn(columnName) {
+		panic("Table " + tableName + " has no column " + columnName)
+	}
+	table.RowFilters = append(table.RowFilters, RowFilter{Column: columnName, Value: filter})
+}
+
+// PrincipalAttribute is a RowFilterFunc that uses an attribute of the
+// principal, e.g. a tenant_id claim of a JWT.
+func PrincipalAttribute(name string) RowFilterFunc {
+	return func(principal *Principal) (interface{}, error) {
+		if principal == nil {
+			return nil, errors.New("anonymous callers have no attribute " + name)
+		}
+		value, ok := principal.Attributes[name]
+		if !ok || value == nil {
+			return nil, errors.New("principal " + principal.Id + " has no attribute " + name)
+		}
+		return value, nil
+	}
+}
+
+func (h *Handler) resolveScope(r Request) ([]scopeValue, error) {
+	table := h.GetTable(r.Table)
+	scope := make([]scopeValue, 0, len(table.RowFilters))
+	for _, filter := range table.RowFilters {
+		value, err := filter.Value(r.Principal)
+		if err != nil {
+			h.logger.Info("Row filter on " + table.Name + "." + filter.Column + " denied the request: " + err.Error())
+			return nil, ApiError{FORBIDDEN}
+		}
+		scope = append(scope, scopeValue{column: filter.Column, value: value})
+	}
+	return scope, nil
+}
+
+func (r Request) isScopeColumn(column string) bool {
+	for _, scope := range r.scope {
+		if scope.column == column {
+			return true
+		}
+	}
+	return false
+}
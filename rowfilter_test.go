@@ -0,0 +1,82 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+)
+
+func getTenantHandlerForTesting(t *testing.T) (*Handler, sqlmock.Sqlmock) {
+	handler, mock := getHandlerForTesting(t)
+	handler.tables["invoices"] = &Table{
+		Name:     "invoices",
+		PKColumn: "id",
+		Columns: []*Column{
+			&Column{Name: "id", Type: "int"},
+			&Column{Name: "tenant_id", Type: "int"},
+			&Column{Name: "amount", Type: "decimal"},
+		},
+		RowFilters: []RowFilter{{Column: "tenant_id", Value: PrincipalAttribute("tenant_id")}},
+	}
+	return handler, mock
+}
+
+var tenantPrincipal = &Principal{Id: "alice", Attributes: map[string]interface{}{"tenant_id": 7}}
+
+func TestGetAllIsScopedToTenant(t *testing.T) {
+	handler, mock := getTenantHandlerForTesting(t)
+	r := Request{Table: "invoices", Action: GET_ALL, Principal: tenantPrincipal, QueryParameters: map[string]interface{}{"amount": "5"}}
+	mock.ExpectPrepare("SELECT \\* FROM invoices WHERE amount LIKE \\? AND tenant_id=\\?").
+		ExpectQuery().
+		WithArgs("%5%", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "amount"}))
+	if _, err := handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestPostForcesTenant(t *testing.T) {
+	handler, mock := getTenantHandlerForTesting(t)
+	data := map[string]interface{}{"amount": 5, "tenant_id": 8}
+	r := Request{Table: "invoices", Action: POST, Data: data, Principal: tenantPrincipal}
+	mock.ExpectPrepare("INSERT INTO invoices \\(amount,tenant_id\\) VALUES \\(\\?,\\?\\)").
+		ExpectExec().
+		WithArgs(5, 7).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare("SELECT \\* FROM invoices WHERE id=\\? AND tenant_id=\\?").
+		ExpectQuery().
+		WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "amount"}).AddRow(1, 7, []byte("5")))
+	if _, err := handler.HandleRequest(r); err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestDeleteIsScopedToTenant(t *testing.T) {
+	handler, mock := getTenantHandlerForTesting(t)
+	r := Request{Table: "invoices", Action: DELETE, Id: 1, Principal: tenantPrincipal}
+	mock.ExpectPrepare("DELETE FROM invoices WHERE id=\\? AND tenant_id=\\?").
+		ExpectExec().
+		WithArgs(1, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != NOT_FOUND {
+		t.Errorf("Expected a 404 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
+
+func TestRowFilterDeniesCallersWithoutTenant(t *testing.T) {
+	handler, mock := getTenantHandlerForTesting(t)
+	r := Request{Table: "invoices", Action: GET_ALL, Principal: &Principal{Id: "bob"}}
+	_, err := handler.HandleRequest(r)
+	if err == nil || err.(ApiError).HTTPStatusCode != FORBIDDEN {
+		t.Errorf("Expected a 403 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(handler)
+}
@@ -0,0 +1,204 @@
+package autorest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	PROCEDURE = "PROCEDURE"
+	FUNCTION  = "FUNCTION"
+)
+
+// Routine is a stored procedure or function, served at POST /rest/_rpc/name.
+type Routine struct {
+	Name       string
+	Type       string
+	Parameters []*RoutineParameter
+}
+
+// RoutineParameter is a parameter of a routine. Mode is IN, OUT or INOUT.
+type RoutineParameter struct {
+	Name string
+	Mode string
+	Type string
+}
+
+func (h *Handler) HasRoutine(name string) bool {
+	_, ok := h.routines[name]
+	return ok && !h.excludedTables[name] && !h.systemTables[name]
+}
+
+func (h *Handler) GetRoutine(name string) *Routine {
+	return h.routines[name]
+}
+
+// Call invokes a routine with the arguments in the request data, in a
+// transaction. Functions respond with their return value, procedures with
+// the rows of every result set they produce and the values of their OUT and
+// INOUT parameters.
+func (h *Handler) Call(r Request) (interface{}, error) {
+	if !h.HasRoutine(r.Table) {
+		return nil, ApiError{NOT_FOUND}
+	}
+	routine := h.GetRoutine(r.Table)
+	if err := checkArguments(routine, r.Data); err != nil {
+		return nil, err
+	}
+	tx, err := h.db.BeginTx(r.context(), nil)
+	if err != nil {
+		return nil, h.databaseError(err)
+	}
+	var result interface{}
+	if routine.Type == FUNCTION {
+		result, err = h.callFunction(tx, r, routine)
+	} else {
+		result, err = h.callProcedure(tx, r, routine)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, h.databaseError(err)
+	}
+	return result, nil
+}
+
+func checkArguments(routine *Routine, arguments map[string]interface{}) error {
+	for name := range arguments {
+		parameter := routine.getParameter(name)
+		if parameter == nil || parameter.Mode == "OUT" {
+			return ApiError{BAD_REQUEST}
+		}
+	}
+	for _, parameter := range routine.Parameters {
+		if _, ok := arguments[parameter.Name]; !ok && parameter.Mode != "OUT" {
+			return ApiError{BAD_REQUEST}
+		}
+	}
+	return nil
+}
+
+func (routine *Routine) getParameter(name string) *RoutineParameter {
+	for _, parameter := range routine.Parameters {
+		if parameter.Name == name {
+			return parameter
+		}
+	}
+	return nil
+}
+
+func (h *Handler) callFunction(db Executor, r Request, routine *Routine) (interface{}, error) {
+	values := make([]interface{}, 0, len(routine.Parameters))
+	for _, parameter := range routine.Parameters {
+		values = append(values, r.Data[parameter.Name])
+	}
+	rows, err := h.query(r.context(), db, h.queryBuilder.BuildFunctionCallQuery(routine), values)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return map[string]interface{}{"result": nil}, nil
+	}
+	return map[string]interface{}{"result": rows[0]["result"]}, nil
+}
+
+// callProcedure passes OUT and INOUT parameters through session variables,
+// which is why procedures are always called in a transaction: it keeps every
+// statement on the same connection.
+func (h *Handler) callProcedure(db Executor, r Request, routine *Routine) (interface{}, error) {
+	variables := make([]string, len(routine.Parameters))
+	values := make([]interface{}, 0)
+	outVariables := make([]string, 0)
+	for i, parameter := range routine.Parameters {
+		switch parameter.Mode {
+		case "OUT", "INOUT":
+			variables[i] = "autorest_" + strconv.Itoa(i)
+			outVariables = append(outVariables, variables[i])
+			if parameter.Mode == "INOUT" {
+				query := h.queryBuilder.BuildSetVariableQuery(variables[i])
+				if _, err := h.exec(r.context(), db, query, []interface{}{r.Data[parameter.Name]}); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			values = append(values, r.Data[parameter.Name])
+		}
+	}
+	results, err := h.queryResultSets(r.context(), db, h.queryBuilder.BuildCallQuery(routine, variables), values)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{})
+	if len(outVariables) > 0 {
+		rows, err := h.query(r.context(), db, h.queryBuilder.BuildSelectVariablesQuery(outVariables), nil)
+		if err != nil {
+			return nil, err
+		}
+		for i, parameter := range routine.Parameters {
+			if variables[i] != "" && len(rows) > 0 {
+				out[parameter.Name] = rows[0][variables[i]]
+			}
+		}
+	}
+	return map[string]interface{}{"results": results, "out": out}, nil
+}
+
+// queryResultSets runs a query that may produce several result sets, like a
+// CALL, and returns the rows of each.
+func (h *Handler) queryResultSets(ctx context.Context, db Executor, query string, values []interface{}) ([][]map[string]interface{}, error) {
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, h.databaseError(err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, values...)
+	if err != nil {
+		return nil, h.databaseError(err)
+	}
+	defer rows.Close()
+	results := make([][]map[string]interface{}, 0)
+	for {
+		if columns, err := rows.Columns(); err == nil && len(columns) > 0 {
+			result, err := h.scanRows(rows)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, h.databaseError(err)
+	}
+	return results, nil
+}
+
+// parseRPCRequest parses a request to call a routine, e.g.
+// /rest/_rpc/close_month. The routine name takes the place of the table.
+func parseRPCRequest(r *http.Request, parts []string) (Request, error) {
+	if len(parts) != 2 || parts[1] == "" {
+		return Request{}, ApiError{NOT_FOUND}
+	}
+	if strings.ToUpper(r.Method) != "POST" {
+		return Request{}, ApiError{METHOD_NOT_SUPPORTED}
+	}
+	data := make(map[string]interface{})
+	if r.ContentLength != 0 {
+		var err error
+		if data, err = parseDataFromRequest(r); err != nil {
+			return Request{}, err
+		}
+	}
+	return Request{
+		Table:  parts[1],
+		Action: RPC,
+		Data:   data,
+		ctx:    r.Context(),
+	}, nil
+}
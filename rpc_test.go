@@ -0,0 +1,94 @@
+package autorest
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getTestingRoutines() map[string]*Routine {
+	return map[string]*Routine{
+		"close_month": &Routine{
+			Name: "close_month",
+			Type: PROCEDURE,
+			Parameters: []*RoutineParameter{
+				&RoutineParameter{Name: "month", Mode: "IN", Type: "date"},
+				&RoutineParameter{Name: "counter", Mode: "INOUT", Type: "int"},
+				&RoutineParameter{Name: "total", Mode: "OUT", Type: "decimal"},
+			},
+		},
+		"tax": &Routine{
+			Name: "tax",
+			Type: FUNCTION,
+			Parameters: []*RoutineParameter{
+				&RoutineParameter{Name: "amount", Mode: "IN", Type: "decimal"},
+			},
+		},
+	}
+}
+
+func TestCallProcedure(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.handler.routines = getTestingRoutines()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SET @autorest_1=\\?").
+		ExpectExec().
+		WithArgs(float64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("CALL `close_month`\\(\\?,@autorest_1,@autorest_2\\)").
+		ExpectQuery().
+		WithArgs("2024-01-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1), sqlmock.NewRows([]string{"name"}).AddRow([]byte("done")))
+	mock.ExpectPrepare("SELECT @autorest_1 AS autorest_1,@autorest_2 AS autorest_2").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"autorest_1", "autorest_2"}).AddRow(4, []byte("12.50")))
+	mock.ExpectCommit()
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("POST", "/rest/_rpc/close_month", strings.NewReader("{\"month\":\"2024-01-01\",\"counter\":3}")))
+	if w.Code != OK {
+		t.Errorf("Expected status code %d but got %d", OK, w.Code)
+	}
+	expected := "{\"out\":{\"counter\":4,\"total\":\"12.50\"},\"results\":[[{\"id\":1}],[{\"name\":\"done\"}]]}"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Expected %s but got %s", expected, body)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestCallFunction(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.handler.routines = getTestingRoutines()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT `tax`\\(\\?\\) AS result").
+		ExpectQuery().
+		WithArgs(float64(100)).
+		WillReturnRows(sqlmock.NewRows([]string{"result"}).AddRow([]byte("21.00")))
+	mock.ExpectCommit()
+	result, err := server.handler.HandleRequest(Request{Table: "tax", Action: RPC, Data: map[string]interface{}{"amount": float64(100)}})
+	if err != nil {
+		t.Errorf("An unexpected error occurred: %s", err)
+	}
+	checkKeyAndValue(t, "result", "21.00", result.(map[string]interface{}))
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestCallChecksArgumentsAndExclusion(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.handler.routines = getTestingRoutines()
+	for _, data := range []map[string]interface{}{{}, {"amount": 1, "other": 2}} {
+		_, err := server.handler.HandleRequest(Request{Table: "tax", Action: RPC, Data: data})
+		if err == nil || err.(ApiError).HTTPStatusCode != BAD_REQUEST {
+			t.Errorf("Expected a 400 error for %v but got %v", data, err)
+		}
+	}
+	server.ExcludeTables("tax")
+	_, err := server.handler.HandleRequest(Request{Table: "tax", Action: RPC, Data: map[string]interface{}{"amount": 1}})
+	if err == nil || err.(ApiError).HTTPStatusCode != NOT_FOUND {
+		t.Errorf("Expected a 404 error but got %v", err)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
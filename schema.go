@@ -0,0 +1,111 @@
+package autorest
+
+import (
+	"strings"
+)
+
+// typeSchema returns the JSON Schema of the values autorest returns for a
+// MySQL data type. DECIMAL and temporal values are returned as strings, since
+// that is how MySQL sends them.
+func typeSchema(dataType string) map[string]interface{} {
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year":
+		return map[string]interface{}{"type": "integer"}
+	case "float", "double", "real":
+		return map[string]interface{}{"type": "number"}
+	case "decimal", "numeric":
+		return map[string]interface{}{"type": "string", "pattern": "^-?[0-9]+(\\.[0-9]+)?$"}
+	case "date":
+		return map[string]interface{}{"type": "string", "format": "date"}
+	case "json", "":
+		return map[string]interface{}{}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// columnSchema returns the JSON Schema of a column, as seen by callers
+// without any exempt roles. The primary key is marked with x-primaryKey.
+func columnSchema(table *Table, column *Column) map[string]interface{} {
+	schema := typeSchema(column.Type)
+	if len(column.Enum) > 0 {
+		schema["enum"] = column.Enum
+	}
+	if column.MaxLength > 0 && schema["type"] == "string" {
+		schema["maxLength"] = column.MaxLength
+	}
+	if column.Nullable {
+		if dataType, ok := schema["type"]; ok {
+			schema["type"] = []interface{}{dataType, "null"}
+		}
+		if enum, ok := schema["enum"].([]string); ok {
+			values := make([]interface{}, 0, len(enum)+1)
+			for _, value := range enum {
+				values = append(values, value)
+			}
+			schema["enum"] = append(values, nil)
+		}
+	}
+	if table.isReadOnlyForEveryone(column) {
+		schema["readOnly"] = true
+	}
+	if column.Name == table.PKColumn {
+		schema["x-primaryKey"] = true
+	}
+	return schema
+}
+
+func computedFieldSchema(field *ComputedField) map[string]interface{} {
+	schema := typeSchema(field.Type)
+	schema["readOnly"] = true
+	return schema
+}
+
+// isReadOnlyForEveryone reports whether clients can never set a column, e.g.
+// an auto increment primary key or a column filled in by autorest.
+func (t *Table) isReadOnlyForEveryone(column *Column) bool {
+	if column.Name == t.PKColumn && column.AutoIncrement {
+		return true
+	}
+	if t.isManagedColumn(column.Name) || t.isRowFilterColumn(column.Name) {
+		return true
+	}
+	return column.Access.ReadOnly && len(column.Access.ExemptRoles) == 0
+}
+
+func (t *Table) isRowFilterColumn(colName string) bool {
+	for _, filter := range t.RowFilters {
+		if filter.Column == colName {
+			return true
+		}
+	}
+	return false
+}
+
+// tableSchema returns the JSON Schema of the rows of a table. Hidden columns
+// are left out, and the columns a row can't be created without are required.
+func tableSchema(table *Table) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for _, column := range table.Columns {
+		if column.Access.Hidden {
+			continue
+		}
+		schema := columnSchema(table, column)
+		properties[column.Name] = schema
+		if !column.Nullable && !column.HasDefault && schema["readOnly"] == nil {
+			required = append(required, column.Name)
+		}
+	}
+	for _, field := range table.ComputedFields {
+		properties[field.Name] = computedFieldSchema(field)
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
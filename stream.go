@@ -0,0 +1,266 @@
+package autorest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// StreamOptions configures streaming of a table's GET_ALL responses. Rows
+// are written as they are read from the database, and flushed to the client
+// every FlushEvery rows. At most MaxRows rows are sent, after which the
+// response ends with an X-Stream-Truncated: true trailer. Zero means no
+// limit.
+//
+// If an error occurs after the first row has been sent, the response ends
+// with an X-Stream-Error trailer holding the status code. JSON arrays are
+// then left unterminated and NDJSON ends with an {"error": ...} line, so a
+// failed response can't be mistaken for a complete one. After hooks of
+// streamed requests receive a nil result.
+type StreamOptions struct {
+	FlushEvery int
+	MaxRows    int64
+}
+
+// StreamEncoder is an Encoder that can also write rows one at a time. JSON,
+// NDJSON and CSV are streamed; other formats are written once all rows have
+// been read.
+type StreamEncoder interface {
+	Encoder
+	NewRowWriter(w io.Writer, columns []string) RowWriter
+}
+
+// RowWriter writes the rows of a streamed response. Close is called once with
+// the error that ended the stream early, or nil.
+type RowWriter interface {
+	WriteRow(row map[string]interface{}) error
+	Flush() error
+	Close(err error) error
+}
+
+// rowStream receives the rows of a GET_ALL as they are read, instead of them
+// being collected into the result.
+type rowStream interface {
+	begin(columns []string) error
+	row(row map[string]interface{}) error
+}
+
+// EnableStreaming streams the GET_ALL responses of a table, or of all tables
+// with ALL_TABLES, so large exports don't have to be held in memory.
+func (s *Server) EnableStreaming(tableName string, options StreamOptions) {
+	if options.FlushEvery <= 0 {
+		options.FlushEvery = 100
+	}
+	if tableName == ALL_TABLES {
+		for _, table := range s.handler.tables {
+			table.Stream = &options
+		}
+		return
+	}
+	s.handler.mustGetTable(tableName).Stream = &options
+}
+
+type responseStream struct {
+	server    *Server
+	w         http.ResponseWriter
+	encoder   StreamEncoder
+	table     *Table
+	options   StreamOptions
+	writer    RowWriter
+	rows      int64
+	truncated bool
+}
+
+// streamFor returns the stream to write the response to a request with, or
+// nil if the response should not be streamed. If the stream has a row limit,
+// the request is limited to one row more, to tell whether it was truncated.
+func (s *Server) streamFor(request *Request, encoder Encoder, w http.ResponseWriter) *responseStream {
+	table := s.handler.GetTable(request.Table)
+	streamEncoder, ok := encoder.(StreamEncoder)
+	if request.Action != GET_ALL || table == nil || table.Stream == nil || !ok {
+		return nil
+	}
+	options := *table.Stream
+	if options.MaxRows > 0 {
+		if limit, _, err := parsePagination(*request); err == nil && (limit < 0 || limit > options.MaxRows) {
+			request.QueryParameters["limit"] = strconv.FormatInt(options.MaxRows+1, 10)
+		}
+	}
+	return &responseStream{server: s, w: w, encoder: streamEncoder, table: table, options: options}
+}
+
+func (s *responseStream) begin(columns []string) error {
+	row := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		row[column] = nil
+	}
+	s.w.Header().Set("Content-Type", s.encoder.ContentType())
+	s.w.Header().Set("Trailer", "X-Stream-Error, X-Stream-Truncated")
+	s.w.WriteHeader(OK)
+	s.writer = s.encoder.NewRowWriter(s.w, fieldOrder(s.table, []map[string]interface{}{row}))
+	return nil
+}
+
+func (s *responseStream) row(row map[string]interface{}) error {
+	if s.options.MaxRows > 0 && s.rows >= s.options.MaxRows {
+		s.truncated = true
+		return errStopRows
+	}
+	if err := s.writer.WriteRow(row); err != nil {
+		return err
+	}
+	s.rows++
+	if s.rows%int64(s.options.FlushEvery) == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *responseStream) flush() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (s *responseStream) started() bool {
+	return s.writer != nil
+}
+
+// end finishes a started stream, with the error that ended it early or nil.
+func (s *responseStream) end(err error) {
+	if err != nil {
+		if _, ok := err.(ApiError); !ok {
+			s.server.logger.Error(err.Error())
+			err = ApiError{INTERNAL_SERVER_ERROR}
+		}
+		s.w.Header().Set("X-Stream-Error", err.Error())
+	}
+	if s.truncated {
+		s.w.Header().Set("X-Stream-Truncated", "true")
+	}
+	if closeErr := s.writer.Close(err); closeErr == nil {
+		s.flush()
+	}
+}
+
+func (JSONEncoder) NewRowWriter(w io.Writer, columns []string) RowWriter {
+	return &jsonRowWriter{w: w}
+}
+
+type jsonRowWriter struct {
+	w    io.Writer
+	rows int
+}
+
+func (j *jsonRowWriter) WriteRow(row map[string]interface{}) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	separator := ","
+	if j.rows == 0 {
+		separator = "["
+	}
+	if _, err = io.WriteString(j.w, separator); err != nil {
+		return err
+	}
+	j.rows++
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonRowWriter) Flush() error {
+	return nil
+}
+
+func (j *jsonRowWriter) Close(err error) error {
+	var end string
+	switch {
+	case err != nil && j.rows == 0:
+		end = "["
+	case err != nil:
+		return nil
+	case j.rows == 0:
+		end = "[]"
+	default:
+		end = "]"
+	}
+	_, err = io.WriteString(j.w, end)
+	return err
+}
+
+func (NDJSONEncoder) NewRowWriter(w io.Writer, columns []string) RowWriter {
+	return &ndjsonRowWriter{json.NewEncoder(w)}
+}
+
+type ndjsonRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonRowWriter) WriteRow(row map[string]interface{}) error {
+	return n.encoder.Encode(row)
+}
+
+func (n *ndjsonRowWriter) Flush() error {
+	return nil
+}
+
+func (n *ndjsonRowWriter) Close(err error) error {
+	if err == nil {
+		return nil
+	}
+	return n.encoder.Encode(map[string]interface{}{
+		"error": map[string]interface{}{"message": "Server returned status code " + err.Error()},
+	})
+}
+
+func (CSVEncoder) NewRowWriter(w io.Writer, columns []string) RowWriter {
+	return &csvRowWriter{writer: csv.NewWriter(w), columns: columns}
+}
+
+type csvRowWriter struct {
+	writer        *csv.Writer
+	columns       []string
+	headerWritten bool
+}
+
+func (c *csvRowWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	return c.writer.Write(c.columns)
+}
+
+func (c *csvRowWriter) WriteRow(row map[string]interface{}) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		value, err := textValue(row[column])
+		if err != nil {
+			return err
+		}
+		record[i] = value
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvRowWriter) Close(err error) error {
+	if headerErr := c.writeHeader(); headerErr != nil {
+		return headerErr
+	}
+	return c.Flush()
+}
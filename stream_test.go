@@ -0,0 +1,75 @@
+package autorest
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStreamingStopsAtMaxRows(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.EnableStreaming("products", StreamOptions{FlushEvery: 1, MaxRows: 2})
+	mock.ExpectPrepare("SELECT \\* FROM products LIMIT \\? OFFSET \\?").
+		ExpectQuery().
+		WithArgs(3, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, []byte("a")).AddRow(2, []byte("b")).AddRow(3, []byte("c")))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/products", nil))
+	expected := `[{"id":1,"name":"a"},{"id":2,"name":"b"}]`
+	if w.Code != OK || w.Body.String() != expected {
+		t.Errorf("Expected %d %s but got %d %s", OK, expected, w.Code, w.Body.String())
+	}
+	if !w.Flushed {
+		t.Error("Expected the rows to be flushed")
+	}
+	if truncated := w.Result().Trailer.Get("X-Stream-Truncated"); truncated != "true" {
+		t.Errorf("Expected the response to be marked as truncated but got %q", truncated)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestStreamingErrorEndsStream(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.EnableStreaming(ALL_TABLES, StreamOptions{})
+	mock.ExpectPrepare("SELECT \\* FROM products").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, []byte("a")).AddRow(2, []byte("b")).RowError(1, errors.New("connection lost")))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/rest/products?format=ndjson", nil))
+	expected := "{\"id\":1,\"name\":\"a\"}\n{\"error\":{\"message\":\"Server returned status code 500\"}}\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, w.Body.String())
+	}
+	if status := w.Result().Trailer.Get("X-Stream-Error"); status != "500" {
+		t.Errorf("Expected an X-Stream-Error trailer of 500 but got %q", status)
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}
+
+func TestStreamingCSV(t *testing.T) {
+	server, mock := getServerForTesting(t)
+	server.EnableStreaming("users", StreamOptions{})
+	mock.ExpectPrepare("SELECT \\* FROM users").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"age", "id"}))
+	mock.ExpectPrepare("SELECT \\* FROM users").
+		WillReturnError(errors.New("table is gone"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/rest/users", nil)
+	r.Header.Set("Accept", "text/csv")
+	server.ServeHTTP(w, r)
+	if w.Code != OK || w.Body.String() != "id,age\n" {
+		t.Errorf("Expected only a header row but got %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if w.Code != INTERNAL_SERVER_ERROR || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected errors before the first row to be reported as usual but got %d %s", w.Code, w.Body.String())
+	}
+	checkExpectationsWereMet(t, mock)
+	cleanUp(server.handler)
+}